@@ -0,0 +1,17 @@
+package auth
+
+import "context"
+
+type contextKey struct{}
+
+// WithCredential returns a copy of ctx that carries the authenticated
+// caller.
+func WithCredential(ctx context.Context, credential *Credential) context.Context {
+	return context.WithValue(ctx, contextKey{}, credential)
+}
+
+// CredentialFromContext returns the caller stored by WithCredential, if any.
+func CredentialFromContext(ctx context.Context) (*Credential, bool) {
+	credential, ok := ctx.Value(contextKey{}).(*Credential)
+	return credential, ok
+}
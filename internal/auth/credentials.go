@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+)
+
+// Credential is an access key pair and the user it belongs to. Several
+// access keys may share one UserID.
+type Credential struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	UserID          string
+	DisplayName     string
+}
+
+type Store struct {
+	credentials map[string]Credential
+}
+
+// LoadCredentials reads a CSV file of access key / secret key pairs,
+// optionally followed by the user ID and display name of the key owner. The
+// user ID defaults to the access key and the display name to the user ID.
+// The first line may be a header starting with "AccessKeyID".
+func LoadCredentials(path string) (*Store, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	store := &Store{
+		credentials: make(map[string]Credential),
+	}
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && record[0] == "AccessKeyID" {
+			continue
+		}
+		if len(record) < 2 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("%s: line %d: expected access key and secret key", path, i+1)
+		}
+
+		credential := Credential{
+			AccessKeyID:     record[0],
+			SecretAccessKey: record[1],
+			UserID:          record[0],
+		}
+		if len(record) > 2 && record[2] != "" {
+			credential.UserID = record[2]
+		}
+		credential.DisplayName = credential.UserID
+		if len(record) > 3 && record[3] != "" {
+			credential.DisplayName = record[3]
+		}
+
+		store.credentials[record[0]] = credential
+	}
+
+	if len(store.credentials) == 0 {
+		return nil, fmt.Errorf("%s: no credentials defined", path)
+	}
+
+	return store, nil
+}
+
+// DisplayName returns the display name of userID, or userID itself if no
+// access key belongs to that user.
+func (s *Store) DisplayName(userID string) string {
+	for _, credential := range s.credentials {
+		if credential.UserID == userID {
+			return credential.DisplayName
+		}
+	}
+	return userID
+}
+
+func (s *Store) Lookup(accessKeyID string) (Credential, bool) {
+	credential, ok := s.credentials[accessKeyID]
+	return credential, ok
+}
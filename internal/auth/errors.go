@@ -0,0 +1,68 @@
+package auth
+
+import "net/http"
+
+// Error is an authentication failure that maps directly onto an S3 error
+// response.
+type Error struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	ErrAccessDenied = &Error{
+		Code:    "AccessDenied",
+		Message: "Access Denied",
+		Status:  http.StatusForbidden,
+	}
+	ErrInvalidAccessKeyID = &Error{
+		Code:    "InvalidAccessKeyId",
+		Message: "The AWS access key ID you provided does not exist in our records",
+		Status:  http.StatusForbidden,
+	}
+	ErrSignatureDoesNotMatch = &Error{
+		Code:    "SignatureDoesNotMatch",
+		Message: "The request signature we calculated does not match the signature you provided",
+		Status:  http.StatusForbidden,
+	}
+	ErrAuthorizationMalformed = &Error{
+		Code:    "AuthorizationHeaderMalformed",
+		Message: "The authorization header or query parameters are malformed",
+		Status:  http.StatusBadRequest,
+	}
+	ErrMissingContentSHA256 = &Error{
+		Code:    "InvalidRequest",
+		Message: "Missing required header for this request: x-amz-content-sha256",
+		Status:  http.StatusBadRequest,
+	}
+	ErrRequestTimeTooSkewed = &Error{
+		Code:    "RequestTimeTooSkewed",
+		Message: "The difference between the request time and the server's time is too large",
+		Status:  http.StatusForbidden,
+	}
+	ErrContentSHA256Mismatch = &Error{
+		Code:    "XAmzContentSHA256Mismatch",
+		Message: "The provided 'x-amz-content-sha256' header does not match what was computed",
+		Status:  http.StatusBadRequest,
+	}
+	ErrRequestExpired = &Error{
+		Code:    "AccessDenied",
+		Message: "Request has expired",
+		Status:  http.StatusForbidden,
+	}
+	ErrRequestNotYetValid = &Error{
+		Code:    "AccessDenied",
+		Message: "Request is not valid yet",
+		Status:  http.StatusForbidden,
+	}
+	ErrIncompleteBody = &Error{
+		Code:    "IncompleteBody",
+		Message: "The request body is not a valid aws-chunked stream",
+		Status:  http.StatusBadRequest,
+	}
+)
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxChunkSize = 16 << 20
+
+// wrapPayload replaces the request body with a reader that enforces the
+// payload hash declared in x-amz-content-sha256.
+func wrapPayload(r *http.Request, sig *signature, key []byte) error {
+	switch sig.payloadHash {
+	case UnsignedPayload:
+		return nil
+	case StreamingPayload, StreamingUnsignedTrailer:
+		decodedLength, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil || decodedLength < 0 {
+			return ErrIncompleteBody
+		}
+
+		r.Body = &chunkedReader{
+			body:          r.Body,
+			r:             bufio.NewReader(r.Body),
+			verify:        sig.payloadHash == StreamingPayload,
+			key:           key,
+			requestTime:   sig.requestTime.UTC().Format(timeFormat),
+			scope:         sig.scope,
+			prevSignature: sig.signature,
+		}
+		r.ContentLength = decodedLength
+		r.Header.Set("Content-Length", strconv.FormatInt(decodedLength, 10))
+		r.Header.Del("X-Amz-Decoded-Content-Length")
+		stripAWSChunked(r.Header)
+		return nil
+	}
+
+	expected, err := hex.DecodeString(sig.payloadHash)
+	if err != nil || len(expected) != sha256.Size {
+		return ErrContentSHA256Mismatch
+	}
+
+	r.Body = &hashingReader{
+		body:     r.Body,
+		hash:     sha256.New(),
+		expected: expected,
+	}
+	return nil
+}
+
+func stripAWSChunked(header http.Header) {
+	encodings := []string{}
+	for _, encoding := range strings.Split(header.Get("Content-Encoding"), ",") {
+		encoding = strings.TrimSpace(encoding)
+		if encoding != "" && encoding != "aws-chunked" {
+			encodings = append(encodings, encoding)
+		}
+	}
+
+	if len(encodings) == 0 {
+		header.Del("Content-Encoding")
+	} else {
+		header.Set("Content-Encoding", strings.Join(encodings, ","))
+	}
+}
+
+// hashingReader reports ErrContentSHA256Mismatch instead of io.EOF when the
+// body does not hash to the signed payload hash.
+type hashingReader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.body.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(h.hash.Sum(nil), h.expected) {
+		return n, ErrContentSHA256Mismatch
+	}
+	return n, err
+}
+
+func (h *hashingReader) Close() error {
+	return h.body.Close()
+}
+
+// chunkedReader decodes an aws-chunked body. For signed streams every chunk
+// signature is chained to the previous one, starting from the seed
+// signature of the request itself.
+type chunkedReader struct {
+	body          io.Closer
+	r             *bufio.Reader
+	verify        bool
+	key           []byte
+	requestTime   string
+	scope         string
+	prevSignature string
+
+	chunk []byte
+	done  bool
+	err   error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+		c.err = c.nextChunk()
+	}
+
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
+
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	sizeField, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return ErrIncompleteBody
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(c.r, data)
+	if err != nil {
+		return ErrIncompleteBody
+	}
+
+	if c.verify {
+		chunkSignature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		if !ok {
+			return ErrIncompleteBody
+		}
+
+		hash := sha256.Sum256(data)
+		stringToSign := strings.Join([]string{
+			algorithm + "-PAYLOAD",
+			c.requestTime,
+			c.scope,
+			c.prevSignature,
+			emptySHA256,
+			hex.EncodeToString(hash[:]),
+		}, "\n")
+		expected := hex.EncodeToString(hmacSHA256(c.key, stringToSign))
+		if !hmac.Equal([]byte(expected), []byte(chunkSignature)) {
+			return ErrSignatureDoesNotMatch
+		}
+		c.prevSignature = chunkSignature
+	}
+
+	if size == 0 {
+		c.done = true
+		return c.skipTrailers()
+	}
+
+	crlf, err := c.readLine()
+	if err != nil || crlf != "" {
+		return ErrIncompleteBody
+	}
+
+	c.chunk = data
+	return nil
+}
+
+// skipTrailers consumes the optional trailing headers after the final chunk
+// up to the terminating empty line.
+func (c *chunkedReader) skipTrailers() error {
+	for {
+		line, err := c.readLine()
+		if err == ErrIncompleteBody {
+			return nil
+		}
+		if err != nil || line == "" {
+			return err
+		}
+	}
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", ErrIncompleteBody
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The signatures of the chunks of the documented aws-chunked upload of
+// 64 KiB and then 1 KiB of the letter a.
+const (
+	streamingSeed   = "4f232c4386841ef735655705268965c44a0e4690baa4adea153f7db9fa80a0a9"
+	streamingFirst  = "ad80c730a21e5b8d04586a2213dd63b9a0e99e0e2307b0ade35a65485a288648"
+	streamingSecond = "0055627c9e194cb4542bae2aa5492e3c1575bbb81b612b7d234b86a503ef5497"
+	streamingFinal  = "b6c6ea8a5354eaf15b3cb7646744f4275b71ea724fed81ceb9323e279d449df9"
+)
+
+func streamingBody() string {
+	return "10000;chunk-signature=" + streamingFirst + "\r\n" + strings.Repeat("a", 65536) + "\r\n" +
+		"400;chunk-signature=" + streamingSecond + "\r\n" + strings.Repeat("a", 1024) + "\r\n" +
+		"0;chunk-signature=" + streamingFinal + "\r\n\r\n"
+}
+
+func streamingRequest(body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPut, "http://s3.amazonaws.com/examplebucket/chunkObject.txt", strings.NewReader(body))
+	r.Header.Set("Content-Encoding", "aws-chunked")
+	r.Header.Set("Content-Length", "66824")
+	r.Header.Set("X-Amz-Content-Sha256", StreamingPayload)
+	r.Header.Set("X-Amz-Date", exampleDate)
+	r.Header.Set("X-Amz-Decoded-Content-Length", "66560")
+	r.Header.Set("X-Amz-Storage-Class", "REDUCED_REDUNDANCY")
+	r.Header.Set("Authorization", algorithm+" Credential="+exampleCredential+
+		",SignedHeaders=content-encoding;content-length;host;x-amz-content-sha256;x-amz-date;x-amz-decoded-content-length;x-amz-storage-class"+
+		",Signature="+streamingSeed)
+	return r
+}
+
+func TestVerifyStreamingExample(t *testing.T) {
+	setNow(t, exampleTime)
+
+	r := streamingRequest(streamingBody())
+	_, err := exampleStore().Verify(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ContentLength != 66560 || r.Header.Get("Content-Encoding") != "" {
+		t.Errorf("decoded request has length %d and encoding %q", r.ContentLength, r.Header.Get("Content-Encoding"))
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("reading the body: %v", err)
+	}
+	if string(body) != strings.Repeat("a", 66560) {
+		t.Errorf("decoded %d bytes, want 66560 bytes of a", len(body))
+	}
+}
+
+func TestVerifyStreamingRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want error
+	}{
+		{"wrong chunk signature", strings.Replace(streamingBody(), streamingSecond, streamingFirst, 1), ErrSignatureDoesNotMatch},
+		{"truncated chunk signature", strings.Replace(streamingBody(), streamingSecond, streamingSecond[:63], 1), ErrSignatureDoesNotMatch},
+		{"wrong final signature", strings.Replace(streamingBody(), streamingFinal, streamingSeed, 1), ErrSignatureDoesNotMatch},
+		{"missing chunk signature", strings.Replace(streamingBody(), ";chunk-signature="+streamingSecond, "", 1), ErrIncompleteBody},
+		{"changed chunk data", strings.Replace(streamingBody(), "a\r\n0;", "b\r\n0;", 1), ErrSignatureDoesNotMatch},
+		{"truncated body", streamingBody()[:66000], ErrIncompleteBody},
+		{"oversized chunk", "1000001;chunk-signature=" + streamingFirst + "\r\n", ErrIncompleteBody},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setNow(t, exampleTime)
+
+			r := streamingRequest(test.body)
+			_, err := exampleStore().Verify(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = io.ReadAll(r.Body)
+			if !errors.Is(err, test.want) {
+				t.Errorf("reading the body: %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// TestVerifyStreamingSeed checks that the seed signature covers the
+// headers of a streaming request like any other.
+func TestVerifyStreamingSeed(t *testing.T) {
+	setNow(t, exampleTime)
+
+	r := streamingRequest(streamingBody())
+	r.Header.Set("X-Amz-Decoded-Content-Length", "66561")
+	_, err := exampleStore().Verify(r)
+	if !errors.Is(err, ErrSignatureDoesNotMatch) {
+		t.Errorf("Verify() = %v, want %v", err, ErrSignatureDoesNotMatch)
+	}
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Presign returns a URL that grants method on bucket/key to whoever holds
+// it until expires has elapsed from now.
+func Presign(credential Credential, method, endpoint, region, bucket, key string, expires time.Duration, now time.Time) (string, error) {
+	base, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return "", err
+	}
+
+	path := "/" + bucket
+	if key != "" {
+		path += "/" + key
+	}
+	base.Path = base.Path + path
+	base.RawPath = ""
+
+	now = now.UTC()
+	sig := &signature{
+		accessKeyID:   credential.AccessKeyID,
+		requestTime:   now,
+		scopeDate:     now.Format(dateFormat),
+		region:        region,
+		signedHeaders: []string{"host"},
+		payloadHash:   UnsignedPayload,
+		presigned:     true,
+	}
+	sig.scope = strings.Join([]string{sig.scopeDate, region, service, terminator}, "/")
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", algorithm)
+	query.Set("X-Amz-Credential", credential.AccessKeyID+"/"+sig.scope)
+	query.Set("X-Amz-Date", now.Format(timeFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	base.RawQuery = canonicalQuery(query, true)
+
+	r, err := http.NewRequest(method, base.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	signingKey := signingKey(credential.SecretAccessKey, sig.scopeDate, region)
+	signature := hex.EncodeToString(hmacSHA256(signingKey, sig.stringToSign(canonicalRequest(r, sig))))
+
+	return base.String() + "&X-Amz-Signature=" + signature, nil
+}
@@ -0,0 +1,357 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+	service    = "s3"
+	terminator = "aws4_request"
+
+	UnsignedPayload          = "UNSIGNED-PAYLOAD"
+	StreamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	emptySHA256  = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	maxClockSkew = 15 * time.Minute
+
+	MaxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// now is the clock requests are checked against; tests replace it to
+// verify signatures made at a fixed time.
+var now = time.Now
+
+// signature holds the parsed SigV4 parameters of a request, whether they
+// came from the Authorization header or from presigned query parameters.
+type signature struct {
+	accessKeyID   string
+	requestTime   time.Time
+	scope         string
+	scopeDate     string
+	region        string
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	presigned     bool
+	expires       time.Duration
+}
+
+// Verify authenticates r with AWS Signature Version 4. On success the
+// request body may be replaced by a reader that validates the payload hash
+// or decodes an aws-chunked stream, so it must be called before the body is
+// read.
+func (s *Store) Verify(r *http.Request) (*Credential, error) {
+	var sig *signature
+	var err error
+
+	authorization := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(authorization, algorithm+" "):
+		sig, err = parseAuthorizationHeader(r, authorization)
+	case authorization != "":
+		return nil, ErrAuthorizationMalformed
+	case r.URL.Query().Has("X-Amz-Algorithm"):
+		sig, err = parsePresignedQuery(r)
+	default:
+		return nil, ErrAccessDenied
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	credential, ok := s.Lookup(sig.accessKeyID)
+	if !ok {
+		return nil, ErrInvalidAccessKeyID
+	}
+
+	age := now().Sub(sig.requestTime)
+	switch {
+	case sig.presigned && age < -maxClockSkew:
+		return nil, ErrRequestNotYetValid
+	case sig.presigned && age > sig.expires:
+		return nil, ErrRequestExpired
+	case !sig.presigned && (age > maxClockSkew || age < -maxClockSkew):
+		return nil, ErrRequestTimeTooSkewed
+	}
+
+	key := signingKey(credential.SecretAccessKey, sig.scopeDate, sig.region)
+	expected := hex.EncodeToString(hmacSHA256(key, sig.stringToSign(canonicalRequest(r, sig))))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return nil, ErrSignatureDoesNotMatch
+	}
+
+	if !sig.presigned {
+		err = wrapPayload(r, sig, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &credential, nil
+}
+
+// Signed reports whether r carries SigV4 credentials in either form.
+// Requests without them are anonymous.
+func Signed(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.URL.Query().Has("X-Amz-Algorithm")
+}
+
+func parseAuthorizationHeader(r *http.Request, authorization string) (*signature, error) {
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(authorization, algorithm+" "), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, ErrAuthorizationMalformed
+		}
+		fields[name] = value
+	}
+
+	requestTime, err := parseRequestTime(r.Header.Get("X-Amz-Date"), r.Header.Get("Date"))
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		return nil, ErrMissingContentSHA256
+	}
+
+	sig := &signature{
+		requestTime: requestTime,
+		signature:   fields["Signature"],
+		payloadHash: payloadHash,
+	}
+
+	err = sig.parseCredential(fields["Credential"])
+	if err != nil {
+		return nil, err
+	}
+
+	err = sig.parseSignedHeaders(fields["SignedHeaders"])
+	if err != nil {
+		return nil, err
+	}
+
+	return sig, nil
+}
+
+func parsePresignedQuery(r *http.Request) (*signature, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != algorithm {
+		return nil, ErrAuthorizationMalformed
+	}
+
+	requestTime, err := time.Parse(timeFormat, query.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, ErrAuthorizationMalformed
+	}
+
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires <= 0 || time.Duration(expires)*time.Second > MaxPresignExpiry {
+		return nil, ErrAuthorizationMalformed
+	}
+
+	payloadHash := query.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = UnsignedPayload
+	}
+
+	sig := &signature{
+		requestTime: requestTime,
+		signature:   query.Get("X-Amz-Signature"),
+		payloadHash: payloadHash,
+		presigned:   true,
+		expires:     time.Duration(expires) * time.Second,
+	}
+
+	err = sig.parseCredential(query.Get("X-Amz-Credential"))
+	if err != nil {
+		return nil, err
+	}
+
+	err = sig.parseSignedHeaders(query.Get("X-Amz-SignedHeaders"))
+	if err != nil {
+		return nil, err
+	}
+
+	return sig, nil
+}
+
+func parseRequestTime(amzDate, date string) (time.Time, error) {
+	if amzDate != "" {
+		t, err := time.Parse(timeFormat, amzDate)
+		if err != nil {
+			return time.Time{}, ErrAuthorizationMalformed
+		}
+		return t, nil
+	}
+
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, ErrAuthorizationMalformed
+	}
+	return t, nil
+}
+
+// parseCredential splits "AKID/20060102/region/s3/aws4_request".
+func (sig *signature) parseCredential(credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[0] == "" || parts[3] != service || parts[4] != terminator {
+		return ErrAuthorizationMalformed
+	}
+	if parts[1] != sig.requestTime.UTC().Format(dateFormat) {
+		return ErrAuthorizationMalformed
+	}
+
+	sig.accessKeyID = parts[0]
+	sig.scopeDate = parts[1]
+	sig.region = parts[2]
+	sig.scope = strings.Join(parts[1:], "/")
+	return nil
+}
+
+func (sig *signature) parseSignedHeaders(signedHeaders string) error {
+	if signedHeaders == "" {
+		return ErrAuthorizationMalformed
+	}
+
+	sig.signedHeaders = strings.Split(strings.ToLower(signedHeaders), ";")
+	for _, name := range sig.signedHeaders {
+		if name == "host" {
+			return nil
+		}
+	}
+	return ErrAuthorizationMalformed
+}
+
+func (sig *signature) stringToSign(canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		algorithm,
+		sig.requestTime.UTC().Format(timeFormat),
+		sig.scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+}
+
+func canonicalRequest(r *http.Request, sig *signature) string {
+	return strings.Join([]string{
+		r.Method,
+		canonicalURI(r.URL),
+		canonicalQuery(r.URL.Query(), sig.presigned),
+		canonicalHeaders(r, sig.signedHeaders),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+}
+
+func canonicalURI(u *url.URL) string {
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			decoded = segment
+		}
+		segments[i] = uriEncode(decoded, false)
+	}
+
+	path := strings.Join(segments, "/")
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func canonicalQuery(query url.Values, presigned bool) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		if presigned && key == "X-Amz-Signature" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func canonicalHeaders(r *http.Request, signedHeaders []string) string {
+	var b strings.Builder
+	for _, name := range signedHeaders {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = r.Header.Values("Content-Length")
+			if len(values) == 0 && r.ContentLength >= 0 {
+				values = []string{strconv.FormatInt(r.ContentLength, 10)}
+			}
+		default:
+			values = r.Header.Values(name)
+		}
+
+		for i, value := range values {
+			values[i] = strings.Join(strings.Fields(value), " ")
+		}
+
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(values, ","))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// uriEncode applies the SigV4 flavour of percent-encoding: only unreserved
+// characters are left as is, and slashes are kept in paths.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%")
+			b.WriteString(strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+func signingKey(secret, date, region string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, terminator)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	"triple-s/internal/policy"
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+const xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+func (h *Handler) GetBucketAcl(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	h.sendACL(w, bucket, bucket.ACL)
+}
+
+func (h *Handler) PutBucketAcl(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	acl, ok := h.requiredACL(w, r)
+	if !ok {
+		return
+	}
+
+	ok = h.updateBucket(w, bucket.Name, "Failed to store bucket ACL", func(bucket *structure.Bucket) {
+		bucket.ACL = acl
+	})
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetObjectAcl(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	object, ok := h.getObjectMetadata(w, bucket.Name, r.PathValue("objectKey"))
+	if !ok {
+		return
+	}
+
+	h.sendACL(w, bucket, object.ACL)
+}
+
+func (h *Handler) PutObjectAcl(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	acl, ok := h.requiredACL(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.storage.UpdateObject(bucket.Name, r.PathValue("objectKey"), func(object *structure.Object) error {
+		object.ACL = acl
+		return nil
+	})
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		h.sendError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to store object ACL", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) getObjectMetadata(w http.ResponseWriter, bucketName, objectKey string) (*structure.Object, bool) {
+	object, err := h.storage.GetObjectMetadata(bucketName, objectKey)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		h.sendError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to retrieve object metadata", http.StatusInternalServerError)
+		return nil, false
+	}
+	return object, true
+}
+
+// cannedACL returns the ACL named in x-amz-acl, or "" when the header is
+// absent. It reports InvalidArgument and returns false for unknown ACLs.
+func (h *Handler) cannedACL(w http.ResponseWriter, r *http.Request) (string, bool) {
+	acl := r.Header.Get("x-amz-acl")
+	if acl != "" && !policy.ValidCannedACL(acl) {
+		h.sendError(w, "InvalidArgument", "The canned ACL "+acl+" is not supported", http.StatusBadRequest)
+		return "", false
+	}
+	return acl, true
+}
+
+// requiredACL is cannedACL for the ?acl subresource, where only canned ACLs
+// are accepted and the header is mandatory.
+func (h *Handler) requiredACL(w http.ResponseWriter, r *http.Request) (string, bool) {
+	acl, ok := h.cannedACL(w, r)
+	if !ok {
+		return "", false
+	}
+	if acl == "" {
+		h.sendError(w, "NotImplemented", "Only canned ACLs set with the x-amz-acl header are supported", http.StatusNotImplemented)
+		return "", false
+	}
+	return acl, true
+}
+
+func (h *Handler) sendACL(w http.ResponseWriter, bucket *structure.Bucket, acl string) {
+	owner := h.bucketOwner(bucket)
+
+	response := structure.AccessControlPolicy{
+		Owner: owner,
+		AccessControlList: structure.AccessControlList{
+			Grants: []structure.Grant{{
+				Grantee: structure.Grantee{
+					XMLNS:       xsiNamespace,
+					Type:        "CanonicalUser",
+					ID:          owner.ID,
+					DisplayName: owner.DisplayName,
+				},
+				Permission: policy.PermissionFullControl,
+			}},
+		},
+	}
+
+	for _, grant := range policy.CannedGrants(acl) {
+		response.AccessControlList.Grants = append(response.AccessControlList.Grants, structure.Grant{
+			Grantee: structure.Grantee{
+				XMLNS: xsiNamespace,
+				Type:  "Group",
+				URI:   grant.Group,
+			},
+			Permission: grant.Permission,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(response)
+}
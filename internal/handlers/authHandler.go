@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"triple-s/internal/auth"
+	"triple-s/internal/policy"
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+// Authenticate verifies the SigV4 signature of every signed request before
+// it is routed. Unsigned requests continue as anonymous and are left to
+// Authorize. Authentication is disabled when no credentials file is
+// configured.
+func (h *Handler) Authenticate(next http.Handler) http.Handler {
+	if h.credentials == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.Signed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		credential, err := h.credentials.Verify(r)
+		if err != nil {
+			if !h.sendAuthError(w, err) {
+				h.sendError(w, "InternalError", "Failed to authenticate request", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithCredential(r.Context(), credential)))
+	})
+}
+
+// Authorize decides whether the caller may perform the request. An explicit
+// Deny in the bucket policy always wins; otherwise the bucket owner is
+// allowed, and anyone else needs an Allow from the policy or a grant from
+// the canned ACL of the bucket or object. Buckets created
+// before ownership was recorded stay accessible to every authenticated
+// user. Requests for missing buckets are passed on to authenticated callers
+// so the handler can report NoSuchBucket or create the bucket.
+func (h *Handler) Authorize(next http.HandlerFunc) http.HandlerFunc {
+	if h.credentials == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, authenticated := auth.CredentialFromContext(r.Context())
+
+		bucketName := r.PathValue("bucketName")
+		if bucketName == "" {
+			if !authenticated {
+				h.sendAuthError(w, auth.ErrAccessDenied)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		bucket, err := h.storage.GetBucket(bucketName)
+		if errors.Is(err, storage.ErrBucketNotFound) {
+			if !authenticated {
+				h.sendAuthError(w, auth.ErrAccessDenied)
+				return
+			}
+			next(w, r)
+			return
+		}
+		if err != nil {
+			h.sendError(w, "InternalError", "Failed to load bucket", http.StatusInternalServerError)
+			return
+		}
+
+		// A batch delete names its keys in the body, so DeleteObjects
+		// authorizes each of them itself.
+		if r.Method == http.MethodPost && r.PathValue("objectKey") == "" && r.URL.Query().Has("delete") {
+			next(w, r)
+			return
+		}
+
+		if !h.allowed(r, bucket, s3Action(r), r.PathValue("objectKey")) {
+			h.sendAuthError(w, auth.ErrAccessDenied)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// allowed applies the rules described on Authorize to action on objectKey
+// in bucket, or on the bucket itself when objectKey is empty.
+func (h *Handler) allowed(r *http.Request, bucket *structure.Bucket, action, objectKey string) bool {
+	credential, authenticated := auth.CredentialFromContext(r.Context())
+
+	decision := policy.NotApplicable
+	if bucketPolicy := parseBucketPolicy(bucket); bucketPolicy != nil {
+		decision = bucketPolicy.Evaluate(policyRequest(r, credential, action, bucket.Name, objectKey))
+	}
+
+	isOwner := authenticated && (bucket.Owner == "" || bucket.Owner == credential.UserID)
+	switch {
+	case decision == policy.Deny:
+		return false
+	case isOwner, decision == policy.Allow:
+		return true
+	}
+	return h.aclAllows(action, bucket, objectKey, authenticated)
+}
+
+// aclAllows checks the canned ACLs for a caller who is not the owner.
+// Listing needs READ on the bucket and writing or deleting objects needs
+// WRITE on the bucket, while reading an object needs READ on the object
+// itself.
+func (h *Handler) aclAllows(action string, bucket *structure.Bucket, objectKey string, authenticated bool) bool {
+	switch action {
+	case "s3:ListBucket", "s3:ListBucketMultipartUploads", "s3:ListBucketVersions":
+		return policy.ACLAllows(bucket.ACL, policy.PermissionRead, authenticated)
+	case "s3:PutObject", "s3:DeleteObject", "s3:AbortMultipartUpload", "s3:ListMultipartUploadParts":
+		return policy.ACLAllows(bucket.ACL, policy.PermissionWrite, authenticated)
+	case "s3:GetObject":
+		object, err := h.storage.GetObjectMetadata(bucket.Name, objectKey)
+		return err == nil && policy.ACLAllows(object.ACL, policy.PermissionRead, authenticated)
+	}
+	return false
+}
+
+func policyRequest(r *http.Request, credential *auth.Credential, action, bucketName, objectKey string) policy.Request {
+	request := policy.Request{
+		Action:   action,
+		Resource: "arn:aws:s3:::" + bucketName,
+		Secure:   r.TLS != nil,
+	}
+	if credential != nil {
+		request.Principal = credential.UserID
+	}
+	if objectKey != "" {
+		request.Resource += "/" + objectKey
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err == nil {
+		request.SourceIP = host
+	}
+
+	if objectKey == "" && request.Action == "s3:ListBucket" {
+		prefix := r.URL.Query().Get("prefix")
+		request.Prefix = &prefix
+	}
+
+	return request
+}
+
+// s3Action names the S3 permission a request needs, following the same
+// method and subresource dispatch as the handlers.
+func s3Action(r *http.Request) string {
+	query := r.URL.Query()
+	isObject := r.PathValue("objectKey") != ""
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case !isObject && query.Has("policy"):
+			return "s3:GetBucketPolicy"
+		case !isObject && query.Has("acl"):
+			return "s3:GetBucketAcl"
+		case !isObject && query.Has("versioning"):
+			return "s3:GetBucketVersioning"
+		case !isObject && query.Has("versions"):
+			return "s3:ListBucketVersions"
+		case !isObject && query.Has("lifecycle"):
+			return "s3:GetLifecycleConfiguration"
+		case !isObject && query.Has("uploads"):
+			return "s3:ListBucketMultipartUploads"
+		case !isObject:
+			return "s3:ListBucket"
+		case query.Has("uploadId"):
+			return "s3:ListMultipartUploadParts"
+		case query.Has("acl"):
+			return "s3:GetObjectAcl"
+		case query.Has("versionId"):
+			return "s3:GetObjectVersion"
+		default:
+			return "s3:GetObject"
+		}
+	case http.MethodPut:
+		switch {
+		case !isObject && query.Has("policy"):
+			return "s3:PutBucketPolicy"
+		case !isObject && query.Has("acl"):
+			return "s3:PutBucketAcl"
+		case !isObject && query.Has("versioning"):
+			return "s3:PutBucketVersioning"
+		case !isObject && query.Has("lifecycle"):
+			return "s3:PutLifecycleConfiguration"
+		case !isObject:
+			return "s3:CreateBucket"
+		case query.Has("acl"):
+			return "s3:PutObjectAcl"
+		default:
+			return "s3:PutObject"
+		}
+	case http.MethodDelete:
+		switch {
+		case !isObject && query.Has("policy"):
+			return "s3:DeleteBucketPolicy"
+		case !isObject && query.Has("lifecycle"):
+			return "s3:PutLifecycleConfiguration"
+		case !isObject:
+			return "s3:DeleteBucket"
+		case query.Has("uploadId"):
+			return "s3:AbortMultipartUpload"
+		case query.Has("versionId"):
+			return "s3:DeleteObjectVersion"
+		default:
+			return "s3:DeleteObject"
+		}
+	case http.MethodPost:
+		if !isObject && query.Has("delete") {
+			return "s3:DeleteObject"
+		}
+		return "s3:PutObject"
+	}
+	return ""
+}
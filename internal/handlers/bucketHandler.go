@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/xml"
+	"errors"
 	"net/http"
 
 	"triple-s/internal/storage"
@@ -12,23 +13,50 @@ import (
 func (h *Handler) PutBucket(w http.ResponseWriter, r *http.Request) {
 	bucketName := r.PathValue("bucketName")
 
+	switch query := r.URL.Query(); {
+	case query.Has("policy"):
+		h.PutBucketPolicy(w, r)
+		return
+	case query.Has("acl"):
+		h.PutBucketAcl(w, r)
+		return
+	case query.Has("versioning"):
+		h.PutBucketVersioning(w, r)
+		return
+	case query.Has("lifecycle"):
+		h.PutBucketLifecycleConfiguration(w, r)
+		return
+	}
+
 	err := v.ValidateBucketName(bucketName)
 	if err != nil {
 		h.sendError(w, "InvalidBucketName", err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	exists, err := storage.BucketExists(h.server.Dir, bucketName)
-	if err != nil {
-		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
+	acl, ok := h.cannedACL(w, r)
+	if !ok {
 		return
 	}
-	if exists {
+
+	bucket := structure.Bucket{
+		Name:  bucketName,
+		Owner: h.owner(r).ID,
+		ACL:   acl,
+	}
+
+	// The backend rejects a name that is taken under the same lock that
+	// adds the bucket, so of two racing requests only one succeeds.
+	err = h.storage.CreateBucket(bucket)
+	if errors.Is(err, storage.ErrBucketExists) {
+		existing, err := h.storage.GetBucket(bucketName)
+		if err == nil && existing.Owner != "" && existing.Owner == bucket.Owner {
+			h.sendError(w, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it", http.StatusConflict)
+			return
+		}
 		h.sendError(w, "BucketAlreadyExists", "Bucket already exists", http.StatusConflict)
 		return
 	}
-
-	err = storage.CreateBucket(h.server.Dir, bucketName)
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to create bucket", http.StatusInternalServerError)
 		return
@@ -38,23 +66,26 @@ func (h *Handler) PutBucket(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetBuckets(w http.ResponseWriter, r *http.Request) {
-	buckets, err := storage.ListBuckets(h.server.Dir)
+	buckets, err := h.storage.ListBuckets()
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to list buckets", http.StatusInternalServerError)
 		return
 	}
 
+	owner := h.owner(r)
+
 	response := structure.ListAllBuckets{
-		Owner: structure.Owner{
-			ID:          "",
-			DisplayName: "",
-		},
+		Owner: owner,
 		Buckets: structure.Buckets{
 			Bucket: make([]structure.Bucket, 0, len(buckets)),
 		},
 	}
 
 	for _, bucket := range buckets {
+		if bucket.Owner != "" && bucket.Owner != owner.ID {
+			continue
+		}
+
 		response.Buckets.Bucket = append(response.Buckets.Bucket, structure.Bucket{
 			Name:         bucket.Name,
 			CreationTime: bucket.CreationTime,
@@ -67,10 +98,10 @@ func (h *Handler) GetBuckets(w http.ResponseWriter, r *http.Request) {
 	xml.NewEncoder(w).Encode(response)
 }
 
-func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HeadBucket(w http.ResponseWriter, r *http.Request) {
 	bucketName := r.PathValue("bucketName")
 
-	exists, err := storage.BucketExists(h.server.Dir, bucketName)
+	exists, err := h.storage.BucketExists(bucketName)
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
 		return
@@ -80,17 +111,32 @@ func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	isEmpty, err := storage.IsBucketEmpty(h.server.Dir, bucketName)
-	if err != nil {
-		h.sendError(w, "InternalError", "Failed to check bucket emptiness", http.StatusInternalServerError)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	switch query := r.URL.Query(); {
+	case query.Has("policy"):
+		h.DeleteBucketPolicy(w, r)
+		return
+	case query.Has("lifecycle"):
+		h.DeleteBucketLifecycle(w, r)
 		return
 	}
-	if !isEmpty {
+
+	// The backend checks that the bucket is empty under the lock that
+	// removes it, so an object stored meanwhile is never deleted with it.
+	err := h.storage.DeleteBucket(bucketName)
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrBucketNotEmpty) {
 		h.sendError(w, "BucketNotEmpty", "Bucket is not empty", http.StatusConflict)
 		return
 	}
-
-	err = storage.DeleteBucket(h.server.Dir, bucketName)
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to delete bucket", http.StatusInternalServerError)
 		return
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"triple-s/internal/structure"
+)
+
+var errBadDigest = errors.New("content MD5 does not match")
+
+func quoteETag(etag string) string {
+	if etag == "" {
+		return ""
+	}
+	return `"` + etag + `"`
+}
+
+// etagMatches reports whether the If-Match / If-None-Match header value
+// contains the object's ETag. Weak validators are compared by value.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates the conditional headers of a GET or HEAD
+// request against object. It writes the 304 or 412 response itself and
+// returns false when the request must not be served.
+func (h *Handler) checkPreconditions(w http.ResponseWriter, r *http.Request, object *structure.Object) bool {
+	lastModified := object.LastModified.Truncate(time.Second)
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && !etagMatches(ifMatch, object.ETag) {
+		h.sendError(w, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+		return false
+	}
+
+	if ifMatch == "" {
+		since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+		if err == nil && lastModified.After(since) {
+			h.sendError(w, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+			return false
+		}
+	}
+
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch != "" && etagMatches(ifNoneMatch, object.ETag) {
+		writeNotModified(w, object)
+		return false
+	}
+
+	if ifNoneMatch == "" {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err == nil && !lastModified.After(since) {
+			writeNotModified(w, object)
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasCopyPreconditions reports whether r sets any of the
+// x-amz-copy-source-if-* headers.
+func hasCopyPreconditions(r *http.Request) bool {
+	for _, header := range []string{
+		"x-amz-copy-source-if-match",
+		"x-amz-copy-source-if-none-match",
+		"x-amz-copy-source-if-modified-since",
+		"x-amz-copy-source-if-unmodified-since",
+	} {
+		if r.Header.Get(header) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCopyPreconditions evaluates the x-amz-copy-source-if-* headers of a
+// copy against the source object. Unlike GET, every failed condition is
+// reported as PreconditionFailed. A matching ETag overrides a failed
+// unmodified-since check, and a non-matching one overrides a failed
+// modified-since check, as in S3.
+func (h *Handler) checkCopyPreconditions(w http.ResponseWriter, r *http.Request, object *structure.Object) bool {
+	lastModified := object.LastModified.Truncate(time.Second)
+
+	ifMatch := r.Header.Get("x-amz-copy-source-if-match")
+	if ifMatch != "" && !etagMatches(ifMatch, object.ETag) {
+		h.sendError(w, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+		return false
+	}
+
+	if ifMatch == "" {
+		since, err := http.ParseTime(r.Header.Get("x-amz-copy-source-if-unmodified-since"))
+		if err == nil && lastModified.After(since) {
+			h.sendError(w, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+			return false
+		}
+	}
+
+	ifNoneMatch := r.Header.Get("x-amz-copy-source-if-none-match")
+	if ifNoneMatch != "" && etagMatches(ifNoneMatch, object.ETag) {
+		h.sendError(w, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+		return false
+	}
+
+	if ifNoneMatch == "" {
+		since, err := http.ParseTime(r.Header.Get("x-amz-copy-source-if-modified-since"))
+		if err == nil && !lastModified.After(since) {
+			h.sendError(w, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+			return false
+		}
+	}
+
+	return true
+}
+
+func writeNotModified(w http.ResponseWriter, object *structure.Object) {
+	w.Header().Set("ETag", quoteETag(object.ETag))
+	w.Header().Set("Last-Modified", object.LastModified.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// digestReader verifies the Content-MD5 of an upload. The mismatch is
+// reported in place of io.EOF so the storage backend discards the data
+// before committing it.
+type digestReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected []byte
+}
+
+func newDigestReader(r io.Reader, contentMD5 string) (io.Reader, error) {
+	expected, err := base64.StdEncoding.DecodeString(contentMD5)
+	if err != nil || len(expected) != md5.Size {
+		return nil, errors.New("invalid Content-MD5")
+	}
+
+	return &digestReader{
+		r:        r,
+		hash:     md5.New(),
+		expected: expected,
+	}, nil
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(d.hash.Sum(nil), d.expected) {
+		return n, errBadDigest
+	}
+	return n, err
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"triple-s/internal/auth"
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+const (
+	directiveCopy    = "COPY"
+	directiveReplace = "REPLACE"
+)
+
+// CopyObject handles a PUT with x-amz-copy-source. The copy keeps the
+// content type, standard headers and user metadata of the source unless
+// x-amz-metadata-directive is REPLACE, and its tags unless
+// x-amz-tagging-directive is REPLACE. Like any new object it gets the ACL
+// from x-amz-acl rather than the source's.
+func (h *Handler) CopyObject(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+
+	srcBucketName, srcKey, srcVersionID, ok := h.copySource(w, r)
+	if !ok {
+		return
+	}
+
+	metadataDirective, ok := h.directive(w, r, "x-amz-metadata-directive")
+	if !ok {
+		return
+	}
+	taggingDirective, ok := h.directive(w, r, "x-amz-tagging-directive")
+	if !ok {
+		return
+	}
+
+	if srcBucketName == bucketName && srcKey == objectKey && srcVersionID == "" && metadataDirective == directiveCopy {
+		h.sendError(w, "InvalidRequest", "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes.", http.StatusBadRequest)
+		return
+	}
+
+	bucket, ok := h.getBucket(w, bucketName)
+	if !ok {
+		return
+	}
+	srcBucket, ok := h.getBucket(w, srcBucketName)
+	if !ok {
+		return
+	}
+
+	action := "s3:GetObject"
+	if srcVersionID != "" {
+		action = "s3:GetObjectVersion"
+	}
+	if h.credentials != nil && !h.allowed(r, srcBucket, action, srcKey) {
+		h.sendAuthError(w, auth.ErrAccessDenied)
+		return
+	}
+
+	source, ok := h.copySourceMetadata(w, srcBucketName, srcKey, srcVersionID)
+	if !ok {
+		return
+	}
+
+	if !h.checkCopyPreconditions(w, r, source) {
+		return
+	}
+
+	acl, ok := h.cannedACL(w, r)
+	if !ok {
+		return
+	}
+
+	versionID, err := storage.VersionIDFor(bucket)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to allocate a version ID", http.StatusInternalServerError)
+		return
+	}
+
+	object := structure.Object{
+		ObjectKey:     objectKey,
+		ContentType:   source.ContentType,
+		LastModified:  time.Now(),
+		ACL:           acl,
+		Tagging:       source.Tagging,
+		VersionID:     versionID,
+		ObjectHeaders: source.ObjectHeaders,
+	}
+
+	if metadataDirective == directiveReplace {
+		object.ContentType = r.Header.Get("Content-Type")
+		if object.ContentType == "" {
+			object.ContentType = "application/octet-stream"
+		}
+
+		object.ObjectHeaders, ok = h.objectHeaders(w, r)
+		if !ok {
+			return
+		}
+	}
+
+	if taggingDirective == directiveReplace {
+		object.Tagging, ok = h.objectTagging(w, r)
+		if !ok {
+			return
+		}
+	}
+
+	// The conditions were checked against metadata read before the copy,
+	// so the copy only goes ahead if the source is still that version.
+	var checked *structure.Object
+	if hasCopyPreconditions(r) {
+		checked = source
+	}
+	err = h.storage.CopyObject(srcBucketName, srcKey, srcVersionID, checked, bucketName, objectKey, &object)
+	if errors.Is(err, storage.ErrObjectChanged) {
+		h.sendError(w, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+		return
+	}
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		h.sendError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrNoSuchVersion) {
+		h.sendError(w, "NoSuchVersion", "The specified version does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to copy object", http.StatusInternalServerError)
+		return
+	}
+
+	if source.VersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", source.VersionID)
+	}
+	setVersionHeader(w, &object)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(structure.CopyObjectResult{
+		LastModified: object.LastModified,
+		ETag:         quoteETag(object.ETag),
+	})
+}
+
+// copySource parses x-amz-copy-source, which names the source as
+// [/]bucket/key with an optional ?versionId=, URL-encoded.
+func (h *Handler) copySource(w http.ResponseWriter, r *http.Request) (string, string, string, bool) {
+	source := r.Header.Get("x-amz-copy-source")
+
+	var versionID string
+	if i := strings.Index(source, "?"); i >= 0 {
+		query, err := url.ParseQuery(source[i+1:])
+		if err != nil || !query.Has("versionId") || query.Get("versionId") == "" {
+			h.sendError(w, "InvalidArgument", "Invalid copy source version ID", http.StatusBadRequest)
+			return "", "", "", false
+		}
+		versionID = query.Get("versionId")
+		source = source[:i]
+	}
+
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		h.sendError(w, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey", http.StatusBadRequest)
+		return "", "", "", false
+	}
+
+	bucketName, key, found := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if !found || bucketName == "" || key == "" {
+		h.sendError(w, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey", http.StatusBadRequest)
+		return "", "", "", false
+	}
+	if !h.checkKey(w, key) {
+		return "", "", "", false
+	}
+
+	return bucketName, key, versionID, true
+}
+
+// copySourceMetadata loads the source object, reporting a missing key or
+// version, or a delete marker, to the client.
+func (h *Handler) copySourceMetadata(w http.ResponseWriter, bucketName, objectKey, versionID string) (*structure.Object, bool) {
+	if versionID == "" {
+		return h.getObjectMetadata(w, bucketName, objectKey)
+	}
+
+	object, err := h.storage.GetObjectVersionMetadata(bucketName, objectKey, versionID)
+	if errors.Is(err, storage.ErrNoSuchVersion) {
+		h.sendError(w, "NoSuchVersion", "The specified version does not exist", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to retrieve object metadata", http.StatusInternalServerError)
+		return nil, false
+	}
+	if object.DeleteMarker {
+		h.sendError(w, "InvalidRequest", "The source of a copy request may not specifically refer to a delete marker by version id.", http.StatusBadRequest)
+		return nil, false
+	}
+	return object, true
+}
+
+// directive reads a COPY or REPLACE directive header, which defaults to COPY.
+func (h *Handler) directive(w http.ResponseWriter, r *http.Request, header string) (string, bool) {
+	value := r.Header.Get(header)
+	switch value {
+	case "", directiveCopy:
+		return directiveCopy, true
+	case directiveReplace:
+		return directiveReplace, true
+	}
+
+	h.sendError(w, "InvalidArgument", "Unknown "+header+" value: "+value, http.StatusBadRequest)
+	return "", false
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+	v "triple-s/internal/validator"
+)
+
+const (
+	maxDeleteObjects  = 1000
+	maxDeleteBodySize = 2 << 20
+)
+
+func (h *Handler) PostBucket(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.Query().Has("delete") {
+		h.sendError(w, "NotImplemented", "A header or query you provided implies functionality that is not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	h.DeleteObjects(w, r)
+}
+
+// DeleteObjects removes up to 1000 keys in one request and reports the
+// outcome of each. In a bucket without versioning the keys are removed
+// together in a single metadata transaction rather than one per key.
+// Deleting a key that does not exist succeeds, as in S3.
+func (h *Handler) DeleteObjects(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	var body io.Reader = r.Body
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		var err error
+		body, err = newDigestReader(r.Body, contentMD5)
+		if err != nil {
+			h.sendError(w, "InvalidDigest", "The Content-MD5 you specified was invalid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var request structure.Delete
+	err := xml.NewDecoder(io.LimitReader(body, maxDeleteBodySize)).Decode(&request)
+	if err == nil {
+		// Read to the end so that the Content-MD5 is checked.
+		_, err = io.Copy(io.Discard, body)
+	}
+	if h.sendAuthError(w, err) {
+		return
+	}
+	if errors.Is(err, errBadDigest) {
+		h.sendError(w, "BadDigest", "The Content-MD5 you specified did not match what was received", http.StatusBadRequest)
+		return
+	}
+	if err != nil || len(request.Objects) == 0 || len(request.Objects) > maxDeleteObjects {
+		h.sendError(w, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", http.StatusBadRequest)
+		return
+	}
+
+	result := structure.DeleteResult{}
+	var batch []string
+	for _, object := range request.Objects {
+		err := v.ValidateObjectKey(object.Key)
+		if errors.Is(err, v.ErrKeyTooLong) {
+			result.Errors = append(result.Errors, deleteError(object, "KeyTooLongError", "Your key is too long"))
+			continue
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, deleteError(object, "InvalidArgument", err.Error()))
+			continue
+		}
+
+		action := "s3:DeleteObject"
+		if object.VersionID != "" {
+			action = "s3:DeleteObjectVersion"
+		}
+		if h.credentials != nil && !h.allowed(r, bucket, action, object.Key) {
+			result.Errors = append(result.Errors, deleteError(object, "AccessDenied", "Access Denied"))
+			continue
+		}
+
+		switch {
+		case object.VersionID != "":
+			removed, err := h.storage.DeleteObjectVersion(bucket.Name, object.Key, object.VersionID)
+			if errors.Is(err, storage.ErrNoSuchVersion) {
+				result.Errors = append(result.Errors, deleteError(object, "NoSuchVersion", "The specified version does not exist"))
+				continue
+			}
+			if err != nil {
+				result.Errors = append(result.Errors, deleteError(object, "InternalError", "Failed to delete object version"))
+				continue
+			}
+
+			deleted := structure.DeletedObject{Key: object.Key, VersionID: object.VersionID}
+			if removed.DeleteMarker {
+				deleted.DeleteMarker = true
+				deleted.DeleteMarkerVersionID = object.VersionID
+			}
+			result.Deleted = append(result.Deleted, deleted)
+
+		case bucket.Versioning != "":
+			versionID, err := storage.VersionIDFor(bucket)
+			if err == nil {
+				err = h.storage.PutDeleteMarker(bucket.Name, object.Key, versionID)
+			}
+			if err != nil {
+				result.Errors = append(result.Errors, deleteError(object, "InternalError", "Failed to delete object"))
+				continue
+			}
+
+			result.Deleted = append(result.Deleted, structure.DeletedObject{
+				Key:                   object.Key,
+				DeleteMarker:          true,
+				DeleteMarkerVersionID: versionID,
+			})
+
+		default:
+			batch = append(batch, object.Key)
+		}
+	}
+
+	if len(batch) > 0 {
+		failed, err := h.storage.DeleteObjects(bucket.Name, batch)
+		for _, objectKey := range batch {
+			if err != nil || failed[objectKey] != nil {
+				result.Errors = append(result.Errors, deleteError(structure.ObjectIdentifier{Key: objectKey}, "InternalError", "Failed to delete object"))
+				continue
+			}
+			result.Deleted = append(result.Deleted, structure.DeletedObject{Key: objectKey})
+		}
+	}
+
+	if request.Quiet {
+		result.Deleted = nil
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(result)
+}
+
+func deleteError(object structure.ObjectIdentifier, code, message string) structure.DeleteError {
+	return structure.DeleteError{
+		Key:       object.Key,
+		VersionID: object.VersionID,
+		Code:      code,
+		Message:   message,
+	}
+}
@@ -2,18 +2,26 @@ package handlers
 
 import (
 	"encoding/xml"
+	"errors"
 	"net/http"
 
+	"triple-s/internal/auth"
+	"triple-s/internal/storage"
 	"triple-s/internal/structure"
+	v "triple-s/internal/validator"
 )
 
 type Handler struct {
-	server *structure.Server
+	server      *structure.Server
+	storage     storage.Backend
+	credentials *auth.Store
 }
 
-func NewHandler(server *structure.Server) *Handler {
+func NewHandler(server *structure.Server, backend storage.Backend, credentials *auth.Store) *Handler {
 	return &Handler{
-		server: server,
+		server:      server,
+		storage:     backend,
+		credentials: credentials,
 	}
 }
 
@@ -28,3 +36,112 @@ func (h *Handler) sendError(w http.ResponseWriter, code, message string, status
 
 	xml.NewEncoder(w).Encode(errorResp)
 }
+
+// sendAuthError reports err to the client if it is an authentication
+// failure, including one detected while the body was being read. It returns
+// false for any other error.
+func (h *Handler) sendAuthError(w http.ResponseWriter, err error) bool {
+	var authErr *auth.Error
+	if !errors.As(err, &authErr) {
+		return false
+	}
+
+	h.sendError(w, authErr.Code, authErr.Message, authErr.Status)
+	return true
+}
+
+// owner returns the authenticated caller as a bucket owner, or the zero
+// Owner when authentication is disabled.
+func (h *Handler) owner(r *http.Request) structure.Owner {
+	credential, ok := auth.CredentialFromContext(r.Context())
+	if !ok {
+		return structure.Owner{}
+	}
+
+	return structure.Owner{
+		ID:          credential.UserID,
+		DisplayName: credential.DisplayName,
+	}
+}
+
+// bucketOwner resolves the display name of the owner of bucket.
+func (h *Handler) bucketOwner(bucket *structure.Bucket) structure.Owner {
+	owner := structure.Owner{ID: bucket.Owner, DisplayName: bucket.Owner}
+	if h.credentials != nil && bucket.Owner != "" {
+		owner.DisplayName = h.credentials.DisplayName(bucket.Owner)
+	}
+	return owner
+}
+
+// getBucket loads the bucket, reporting NoSuchBucket or InternalError to the
+// client when it cannot.
+func (h *Handler) getBucket(w http.ResponseWriter, bucketName string) (*structure.Bucket, bool) {
+	bucket, err := h.storage.GetBucket(bucketName)
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to load bucket", http.StatusInternalServerError)
+		return nil, false
+	}
+	return bucket, true
+}
+
+// updateBucket changes the stored attributes of a bucket with update,
+// reporting NoSuchBucket, or InternalError with message, to the client when
+// it cannot.
+func (h *Handler) updateBucket(w http.ResponseWriter, bucketName, message string, update func(*structure.Bucket)) bool {
+	err := h.storage.UpdateBucket(bucketName, func(bucket *structure.Bucket) error {
+		update(bucket)
+		return nil
+	})
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", message, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) checkBucket(w http.ResponseWriter, bucketName string) bool {
+	exists, err := h.storage.BucketExists(bucketName)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
+		return false
+	}
+	if !exists {
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// ValidateKey rejects a request for an object key that ValidateObjectKey
+// does not accept before it reaches next.
+func (h *Handler) ValidateKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.checkKey(w, r.PathValue("objectKey")) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkKey reports an object key that ValidateObjectKey does not accept to
+// the client.
+func (h *Handler) checkKey(w http.ResponseWriter, objectKey string) bool {
+	err := v.ValidateObjectKey(objectKey)
+	if errors.Is(err, v.ErrKeyTooLong) {
+		h.sendError(w, "KeyTooLongError", "Your key is too long", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		h.sendError(w, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+
+	"triple-s/internal/lifecycle"
+	"triple-s/internal/structure"
+)
+
+func (h *Handler) PutBucketLifecycleConfiguration(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	document, err := io.ReadAll(io.LimitReader(r.Body, lifecycle.MaxSize+1))
+	if h.sendAuthError(w, err) {
+		return
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to read lifecycle configuration", http.StatusInternalServerError)
+		return
+	}
+
+	configuration, err := lifecycle.Parse(document)
+	if errors.Is(err, lifecycle.ErrMalformed) {
+		h.sendError(w, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.sendError(w, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored, err := xml.Marshal(configuration)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to store lifecycle configuration", http.StatusInternalServerError)
+		return
+	}
+
+	ok = h.updateBucket(w, bucket.Name, "Failed to store lifecycle configuration", func(bucket *structure.Bucket) {
+		bucket.Lifecycle = string(stored)
+	})
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBucketLifecycleConfiguration(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	if bucket.Lifecycle == "" {
+		h.sendError(w, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	io.WriteString(w, bucket.Lifecycle)
+}
+
+func (h *Handler) DeleteBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	if bucket.Lifecycle != "" {
+		ok = h.updateBucket(w, bucket.Name, "Failed to delete lifecycle configuration", func(bucket *structure.Bucket) {
+			bucket.Lifecycle = ""
+		})
+		if !ok {
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+const defaultMaxKeys = 1000
+
+func (h *Handler) ListObjects(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	query := r.URL.Query()
+
+	switch {
+	case query.Has("uploads"):
+		h.ListMultipartUploads(w, r)
+		return
+	case query.Has("policy"):
+		h.GetBucketPolicy(w, r)
+		return
+	case query.Has("acl"):
+		h.GetBucketAcl(w, r)
+		return
+	case query.Has("versioning"):
+		h.GetBucketVersioning(w, r)
+		return
+	case query.Has("versions"):
+		h.ListObjectVersions(w, r)
+		return
+	case query.Has("lifecycle"):
+		h.GetBucketLifecycleConfiguration(w, r)
+		return
+	}
+
+	listType := query.Get("list-type")
+	if listType != "" && listType != "2" {
+		h.sendError(w, "InvalidArgument", "Only list-type=2 is supported", http.StatusBadRequest)
+		return
+	}
+
+	maxKeys := defaultMaxKeys
+	if maxKeysStr := query.Get("max-keys"); maxKeysStr != "" {
+		n, err := strconv.Atoi(maxKeysStr)
+		if err != nil || n < 0 {
+			h.sendError(w, "InvalidArgument", "max-keys must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		maxKeys = min(n, defaultMaxKeys)
+	}
+
+	marker := query.Get("start-after")
+	token := query.Get("continuation-token")
+	if token != "" {
+		decoded, err := base64.URLEncoding.DecodeString(token)
+		if err != nil {
+			h.sendError(w, "InvalidArgument", "The continuation token provided is incorrect", http.StatusBadRequest)
+			return
+		}
+		marker = string(decoded)
+	}
+
+	response := structure.ListBucketResult{
+		Name:              bucketName,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		MaxKeys:           maxKeys,
+		ContinuationToken: token,
+		StartAfter:        query.Get("start-after"),
+		Contents:          []structure.Contents{},
+		CommonPrefixes:    []structure.CommonPrefix{},
+	}
+
+	// Only the objects after the marker are read, and only until the page
+	// is full.
+	last := ""
+	err := h.storage.WalkObjects(bucketName, response.Prefix, marker, func(object structure.Object) bool {
+		key := object.ObjectKey
+		commonPrefix := ""
+		if response.Delimiter != "" {
+			rest := key[len(response.Prefix):]
+			if idx := strings.Index(rest, response.Delimiter); idx >= 0 {
+				commonPrefix = response.Prefix + rest[:idx+len(response.Delimiter)]
+			}
+		}
+		if commonPrefix != "" && (commonPrefix <= marker || commonPrefix == last) {
+			return true
+		}
+
+		if response.KeyCount == maxKeys {
+			response.IsTruncated = maxKeys > 0
+			return false
+		}
+
+		if commonPrefix != "" {
+			response.CommonPrefixes = append(response.CommonPrefixes, structure.CommonPrefix{Prefix: commonPrefix})
+			last = commonPrefix
+		} else {
+			response.Contents = append(response.Contents, structure.Contents{
+				Key:          key,
+				LastModified: object.LastModified,
+				ETag:         quoteETag(object.ETag),
+				Size:         object.Size,
+				StorageClass: "STANDARD",
+			})
+			last = key
+		}
+		response.KeyCount++
+		return true
+	})
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to list objects", http.StatusInternalServerError)
+		return
+	}
+
+	if response.IsTruncated {
+		response.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(last))
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(response)
+}
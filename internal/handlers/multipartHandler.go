@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+const (
+	defaultMaxParts   = 1000
+	defaultMaxUploads = 1000
+
+	maxCompleteBodySize = 1 << 20
+)
+
+func (h *Handler) PostObject(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	switch {
+	case query.Has("uploads"):
+		h.CreateMultipartUpload(w, r)
+	case query.Has("uploadId"):
+		h.CompleteMultipartUpload(w, r)
+	default:
+		h.sendError(w, "NotImplemented", "A header or query you provided implies functionality that is not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (h *Handler) CreateMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+
+	if !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	acl, ok := h.cannedACL(w, r)
+	if !ok {
+		return
+	}
+
+	tagging, ok := h.objectTagging(w, r)
+	if !ok {
+		return
+	}
+
+	headers, ok := h.objectHeaders(w, r)
+	if !ok {
+		return
+	}
+
+	object := structure.Object{
+		ObjectKey:     objectKey,
+		ContentType:   contentType,
+		ACL:           acl,
+		Tagging:       tagging,
+		ObjectHeaders: headers,
+	}
+
+	uploadID, err := h.storage.CreateMultipartUpload(bucketName, object)
+	if err != nil {
+		h.sendMultipartError(w, err, "Failed to create multipart upload")
+		return
+	}
+
+	response := structure.InitiateMultipartUploadResult{
+		Bucket:   bucketName,
+		Key:      objectKey,
+		UploadID: uploadID,
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) UploadPart(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+	uploadID := r.URL.Query().Get("uploadId")
+
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > storage.MaxPartNumber {
+		h.sendError(w, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive", http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	var body io.Reader = r.Body
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		body, err = newDigestReader(r.Body, contentMD5)
+		if err != nil {
+			h.sendError(w, "InvalidDigest", "The Content-MD5 you specified was invalid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	part, err := h.storage.UploadPart(bucketName, objectKey, uploadID, partNumber, body)
+	if errors.Is(err, errBadDigest) {
+		h.sendError(w, "BadDigest", "The Content-MD5 you specified did not match what was received", http.StatusBadRequest)
+		return
+	}
+	if h.sendAuthError(w, err) {
+		return
+	}
+	if err != nil {
+		h.sendMultipartError(w, err, "Failed to store part")
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(part.ETag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+	uploadID := r.URL.Query().Get("uploadId")
+
+	bucket, ok := h.getBucket(w, bucketName)
+	if !ok {
+		return
+	}
+
+	versionID, err := storage.VersionIDFor(bucket)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to allocate a version ID", http.StatusInternalServerError)
+		return
+	}
+
+	var request structure.CompleteMultipartUpload
+	err = xml.NewDecoder(io.LimitReader(r.Body, maxCompleteBodySize)).Decode(&request)
+	if err != nil {
+		h.sendError(w, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", http.StatusBadRequest)
+		return
+	}
+
+	object, err := h.storage.CompleteMultipartUpload(bucketName, objectKey, uploadID, request.Parts, versionID)
+	if err != nil {
+		h.sendMultipartError(w, err, "Failed to complete multipart upload")
+		return
+	}
+
+	response := structure.CompleteMultipartUploadResult{
+		Location: "http://" + r.Host + "/" + bucketName + "/" + objectKey,
+		Bucket:   bucketName,
+		Key:      objectKey,
+		ETag:     quoteETag(object.ETag),
+	}
+
+	setVersionHeader(w, object)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) AbortMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+	uploadID := r.URL.Query().Get("uploadId")
+
+	if !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	err := h.storage.AbortMultipartUpload(bucketName, objectKey, uploadID)
+	if err != nil {
+		h.sendMultipartError(w, err, "Failed to abort multipart upload")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListParts(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	maxParts, ok := parseLimit(query.Get("max-parts"), defaultMaxParts)
+	if !ok {
+		h.sendError(w, "InvalidArgument", "max-parts must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	marker, ok := parseLimit(query.Get("part-number-marker"), 0)
+	if !ok {
+		h.sendError(w, "InvalidArgument", "part-number-marker must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	parts, err := h.storage.ListParts(bucketName, objectKey, uploadID)
+	if err != nil {
+		h.sendMultipartError(w, err, "Failed to list parts")
+		return
+	}
+
+	response := structure.ListPartsResult{
+		Bucket:           bucketName,
+		Key:              objectKey,
+		UploadID:         uploadID,
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+		Parts:            []structure.Part{},
+	}
+
+	for _, part := range parts {
+		if part.PartNumber <= marker {
+			continue
+		}
+		if len(response.Parts) == maxParts {
+			response.IsTruncated = maxParts > 0
+			break
+		}
+
+		part.ETag = quoteETag(part.ETag)
+		response.Parts = append(response.Parts, part)
+		response.NextPartNumberMarker = part.PartNumber
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) ListMultipartUploads(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	query := r.URL.Query()
+
+	maxUploads, ok := parseLimit(query.Get("max-uploads"), defaultMaxUploads)
+	if !ok {
+		h.sendError(w, "InvalidArgument", "max-uploads must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkBucket(w, bucketName) {
+		return
+	}
+
+	uploads, err := h.storage.ListMultipartUploads(bucketName)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to list multipart uploads", http.StatusInternalServerError)
+		return
+	}
+
+	response := structure.ListMultipartUploadsResult{
+		Bucket:         bucketName,
+		KeyMarker:      query.Get("key-marker"),
+		UploadIDMarker: query.Get("upload-id-marker"),
+		Prefix:         query.Get("prefix"),
+		MaxUploads:     maxUploads,
+		Uploads:        []structure.Upload{},
+	}
+
+	// Uploads are ordered by key and then by initiation time, so the
+	// upload-id-marker only applies within the key named by key-marker.
+	passedMarker := false
+	for _, upload := range uploads {
+		if !strings.HasPrefix(upload.Key, response.Prefix) || upload.Key < response.KeyMarker {
+			continue
+		}
+		if response.KeyMarker != "" && upload.Key == response.KeyMarker && !passedMarker {
+			if response.UploadIDMarker != "" && upload.UploadID == response.UploadIDMarker {
+				passedMarker = true
+			}
+			continue
+		}
+		if len(response.Uploads) == maxUploads {
+			response.IsTruncated = maxUploads > 0
+			break
+		}
+
+		response.Uploads = append(response.Uploads, upload)
+		response.NextKeyMarker = upload.Key
+		response.NextUploadIDMarker = upload.UploadID
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) sendMultipartError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, storage.ErrNoSuchUpload):
+		h.sendError(w, "NoSuchUpload", "The specified multipart upload does not exist", http.StatusNotFound)
+	case errors.Is(err, storage.ErrInvalidPart):
+		h.sendError(w, "InvalidPart", "One or more of the specified parts could not be found", http.StatusBadRequest)
+	case errors.Is(err, storage.ErrInvalidPartOrder):
+		h.sendError(w, "InvalidPartOrder", "The list of parts was not in ascending order", http.StatusBadRequest)
+	case errors.Is(err, storage.ErrEntityTooSmall):
+		h.sendError(w, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size", http.StatusBadRequest)
+	default:
+		h.sendError(w, "InternalError", message, http.StatusInternalServerError)
+	}
+}
+
+func parseLimit(value string, fallback int) (int, bool) {
+	if value == "" {
+		return fallback, true
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	if fallback > 0 {
+		n = min(n, fallback)
+	}
+	return n, true
+}
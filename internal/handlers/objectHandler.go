@@ -1,27 +1,48 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"triple-s/internal/auth"
 	"triple-s/internal/storage"
 	"triple-s/internal/structure"
 )
 
+const (
+	metadataPrefix  = "x-amz-meta-"
+	maxMetadataSize = 2 << 10
+
+	maxTags           = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
 func (h *Handler) PutObject(w http.ResponseWriter, r *http.Request) {
+	switch query := r.URL.Query(); {
+	case query.Has("uploadId"):
+		h.UploadPart(w, r)
+		return
+	case query.Has("acl"):
+		h.PutObjectAcl(w, r)
+		return
+	case r.Header.Get("x-amz-copy-source") != "":
+		h.CopyObject(w, r)
+		return
+	}
+
 	bucketName := r.PathValue("bucketName")
 	objectKey := r.PathValue("objectKey")
 
-	exists, err := storage.BucketExists(h.server.Dir, bucketName)
-	if err != nil {
-		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
-		return
-	}
-	if !exists {
-		h.sendError(w, "NoSuchBucket", "The specified bucket does not exists", http.StatusNotFound)
+	bucket, ok := h.getBucket(w, bucketName)
+	if !ok {
 		return
 	}
 
@@ -30,46 +51,94 @@ func (h *Handler) PutObject(w http.ResponseWriter, r *http.Request) {
 		contentType = "application/octet-stream"
 	}
 
+	acl, ok := h.cannedACL(w, r)
+	if !ok {
+		return
+	}
+
+	tagging, ok := h.objectTagging(w, r)
+	if !ok {
+		return
+	}
+
+	headers, ok := h.objectHeaders(w, r)
+	if !ok {
+		return
+	}
+
+	versionID, err := storage.VersionIDFor(bucket)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to allocate a version ID", http.StatusInternalServerError)
+		return
+	}
+
 	contentLenStr := r.Header.Get("Content-Length")
-	var contentLen int64
 	if contentLenStr != "" {
-		contentLen, err = strconv.ParseInt(contentLenStr, 10, 64)
+		_, err = strconv.ParseInt(contentLenStr, 10, 64)
 		if err != nil {
 			h.sendError(w, "InvalidContentLength", "Content length is not valid", http.StatusBadRequest)
 			return
 		}
 	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		h.sendError(w, "InternalError", "Failed to read request body", http.StatusInternalServerError)
-		return
-	}
-	if contentLen == 0 {
-		contentLen = int64(len(body))
+	var body io.Reader = r.Body
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		body, err = newDigestReader(r.Body, contentMD5)
+		if err != nil {
+			h.sendError(w, "InvalidDigest", "The Content-MD5 you specified was invalid", http.StatusBadRequest)
+			return
+		}
 	}
 
 	object := structure.Object{
-		ObjectKey:    objectKey,
-		Size:         contentLen,
-		ContentType:  contentType,
-		LastModified: time.Now(),
+		ObjectKey:     objectKey,
+		ContentType:   contentType,
+		LastModified:  time.Now(),
+		ACL:           acl,
+		Tagging:       tagging,
+		VersionID:     versionID,
+		ObjectHeaders: headers,
 	}
 
-	err = storage.StoreObject(h.server.Dir, bucketName, objectKey, body, object)
+	// If-None-Match: * is checked by the backend under the same lock that
+	// stores the object, so two such writes cannot both succeed.
+	createOnly := r.Header.Get("If-None-Match") == "*"
+	err = h.storage.StoreObject(bucketName, objectKey, body, &object, createOnly)
+	if errors.Is(err, storage.ErrObjectExists) {
+		h.sendError(w, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", http.StatusPreconditionFailed)
+		return
+	}
+	if errors.Is(err, errBadDigest) {
+		h.sendError(w, "BadDigest", "The Content-MD5 you specified did not match what was received", http.StatusBadRequest)
+		return
+	}
+	if h.sendAuthError(w, err) {
+		return
+	}
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to store object", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", quoteETag(object.ETag))
+	setVersionHeader(w, &object)
 	w.WriteHeader(http.StatusOK)
 }
 
 func (h *Handler) GetObject(w http.ResponseWriter, r *http.Request) {
+	switch query := r.URL.Query(); {
+	case query.Has("uploadId"):
+		h.ListParts(w, r)
+		return
+	case query.Has("acl"):
+		h.GetObjectAcl(w, r)
+		return
+	}
+
 	bucketName := r.PathValue("bucketName")
 	objectKey := r.PathValue("objectKey")
 
-	exists, err := storage.BucketExists(h.server.Dir, bucketName)
+	exists, err := h.storage.BucketExists(bucketName)
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
 		return
@@ -79,51 +148,316 @@ func (h *Handler) GetObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	objectExists, err := storage.ObjectExists(h.server.Dir, bucketName, objectKey)
-	if err != nil {
-		h.sendError(w, "InternalError", "Failed to check object existence", http.StatusInternalServerError)
+	object, data, ok := h.openObject(w, r, bucketName, objectKey)
+	if !ok {
 		return
 	}
-	if !objectExists {
-		h.sendError(w, "NoSuchKey", "The specified key does not exists", http.StatusNotFound)
+	defer data.Close()
+
+	if !h.checkPreconditions(w, r, object) {
 		return
 	}
 
-	object, err := storage.GetObjectMetadata(h.server.Dir, bucketName, objectKey)
+	if hasResponseOverrides(r) && h.credentials != nil {
+		if _, ok := auth.CredentialFromContext(r.Context()); !ok {
+			h.sendError(w, "InvalidRequest", "Request specific response headers cannot be used for anonymous GET requests.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	byteRange, err := parseRange(r.Header.Get("Range"), object.Size)
 	if err != nil {
-		h.sendError(w, "InternalError", "Failed to get object metadata", http.StatusInternalServerError)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", object.Size))
+		h.sendError(w, "InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	setObjectHeaders(w, object)
+	setResponseOverrides(w, r)
+
+	if byteRange == nil {
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, data)
 		return
 	}
 
-	data, err := storage.GetObject(h.server.Dir, bucketName, objectKey)
+	_, err = data.Seek(byteRange.start, io.SeekStart)
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to read object", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Range", byteRange.contentRange(object.Size))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", byteRange.length))
+	w.WriteHeader(http.StatusPartialContent)
+	io.CopyN(w, data, byteRange.length)
+}
+
+func (h *Handler) HeadObject(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+	objectKey := r.PathValue("objectKey")
+
+	exists, err := h.storage.BucketExists(bucketName)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	object, ok := h.lookupObject(w, r, bucketName, objectKey)
+	if !ok {
+		return
+	}
+
+	if !h.checkPreconditions(w, r, object) {
+		return
+	}
+
+	setObjectHeaders(w, object)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// lookupObject loads the metadata of the version named by ?versionId, or of
+// the current object when there is none. Delete markers cannot be read and
+// are reported as MethodNotAllowed.
+func (h *Handler) lookupObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) (*structure.Object, bool) {
+	object, _, ok := h.findObject(w, r, bucketName, objectKey, false)
+	return object, ok
+}
+
+// openObject is lookupObject that also opens the data of the object. The
+// backend reads both under one lock, so they belong to the same version
+// even if the key is overwritten meanwhile.
+func (h *Handler) openObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) (*structure.Object, io.ReadSeekCloser, bool) {
+	return h.findObject(w, r, bucketName, objectKey, true)
+}
+
+func (h *Handler) findObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string, open bool) (*structure.Object, io.ReadSeekCloser, bool) {
+	var object *structure.Object
+	var data io.ReadSeekCloser
+	var err error
+
+	query := r.URL.Query()
+	if !query.Has("versionId") {
+		if open {
+			object, data, err = h.storage.GetObject(bucketName, objectKey)
+		} else {
+			object, err = h.storage.GetObjectMetadata(bucketName, objectKey)
+		}
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			h.sendError(w, "NoSuchKey", "The specified key does not exist", http.StatusNotFound)
+			return nil, nil, false
+		}
+		if err != nil {
+			h.sendError(w, "InternalError", "Failed to get object metadata", http.StatusInternalServerError)
+			return nil, nil, false
+		}
+		return object, data, true
+	}
+
+	if open {
+		object, data, err = h.storage.GetObjectVersion(bucketName, objectKey, query.Get("versionId"))
+	} else {
+		object, err = h.storage.GetObjectVersionMetadata(bucketName, objectKey, query.Get("versionId"))
+	}
+	if errors.Is(err, storage.ErrNoSuchVersion) {
+		h.sendError(w, "NoSuchVersion", "The specified version does not exist", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to get object metadata", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if object.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+		setVersionHeader(w, object)
+		h.sendError(w, "MethodNotAllowed", "The specified method is not allowed against this resource", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+	return object, data, true
+}
+
+func setObjectHeaders(w http.ResponseWriter, object *structure.Object) {
 	w.Header().Set("Content-Type", object.ContentType)
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", object.Size))
 	w.Header().Set("Last-Modified", object.LastModified.Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if object.ETag != "" {
+		w.Header().Set("ETag", quoteETag(object.ETag))
+	}
+	if object.ContentDisposition != "" {
+		w.Header().Set("Content-Disposition", object.ContentDisposition)
+	}
+	if object.ContentEncoding != "" {
+		w.Header().Set("Content-Encoding", object.ContentEncoding)
+	}
+	if object.CacheControl != "" {
+		w.Header().Set("Cache-Control", object.CacheControl)
+	}
+	if object.Expires != "" {
+		w.Header().Set("Expires", object.Expires)
+	}
+	for name, value := range object.Metadata {
+		w.Header().Set(metadataPrefix+name, value)
+	}
+	if object.Tagging != "" {
+		tags, _ := url.ParseQuery(object.Tagging)
+		w.Header().Set("x-amz-tagging-count", strconv.Itoa(len(tags)))
+	}
+	setVersionHeader(w, object)
+}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
+// responseOverrides maps the query parameters of a GET request to the
+// response headers they replace.
+var responseOverrides = map[string]string{
+	"response-content-type":        "Content-Type",
+	"response-content-language":    "Content-Language",
+	"response-expires":             "Expires",
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+}
+
+func hasResponseOverrides(r *http.Request) bool {
+	query := r.URL.Query()
+	for parameter := range responseOverrides {
+		if query.Has(parameter) {
+			return true
+		}
+	}
+	return false
+}
+
+func setResponseOverrides(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	for parameter, header := range responseOverrides {
+		if query.Has(parameter) {
+			w.Header().Set(header, query.Get(parameter))
+		}
+	}
+}
+
+// objectHeaders collects the standard headers and x-amz-meta-* metadata of
+// an upload. It reports MetadataTooLarge and returns false when the user
+// metadata exceeds the S3 limit.
+func (h *Handler) objectHeaders(w http.ResponseWriter, r *http.Request) (structure.ObjectHeaders, bool) {
+	headers := structure.ObjectHeaders{
+		ContentDisposition: r.Header.Get("Content-Disposition"),
+		ContentEncoding:    r.Header.Get("Content-Encoding"),
+		CacheControl:       r.Header.Get("Cache-Control"),
+		Expires:            r.Header.Get("Expires"),
+	}
+
+	size := 0
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, metadataPrefix) {
+			continue
+		}
+		if headers.Metadata == nil {
+			headers.Metadata = make(map[string]string)
+		}
+
+		name = strings.TrimPrefix(name, metadataPrefix)
+		value := strings.Join(values, ",")
+		headers.Metadata[name] = value
+		size += len(name) + len(value)
+	}
+	if size > maxMetadataSize {
+		h.sendError(w, "MetadataTooLarge", "Your metadata headers exceed the maximum allowed metadata size", http.StatusBadRequest)
+		return headers, false
+	}
+
+	return headers, true
+}
+
+// objectTagging returns the tag set given in x-amz-tagging, URL-encoded with
+// sorted keys, or "" when the header is absent. It reports InvalidTag and
+// returns false when the tag set does not follow the S3 limits.
+func (h *Handler) objectTagging(w http.ResponseWriter, r *http.Request) (string, bool) {
+	header := r.Header.Get("x-amz-tagging")
+	if header == "" {
+		return "", true
+	}
+
+	tags, err := url.ParseQuery(header)
+	if err != nil {
+		h.sendError(w, "InvalidArgument", "The header 'x-amz-tagging' shall be encoded as UTF-8 then URLEncoded URL query parameters without tag name duplicates.", http.StatusBadRequest)
+		return "", false
+	}
+	if len(tags) > maxTags {
+		h.sendError(w, "BadRequest", "Object tags cannot be greater than 10", http.StatusBadRequest)
+		return "", false
+	}
+	for key, values := range tags {
+		if len(values) > 1 {
+			h.sendError(w, "InvalidTag", "Cannot provide multiple Tags with the same key", http.StatusBadRequest)
+			return "", false
+		}
+		if key == "" || utf8.RuneCountInString(key) > maxTagKeyLength || utf8.RuneCountInString(values[0]) > maxTagValueLength {
+			h.sendError(w, "InvalidTag", "The TagKey or TagValue you have provided is too long", http.StatusBadRequest)
+			return "", false
+		}
+	}
+
+	return tags.Encode(), true
 }
 
 func (h *Handler) DeleteObject(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Has("uploadId") {
+		h.AbortMultipartUpload(w, r)
+		return
+	}
+
 	bucketName := r.PathValue("bucketName")
 	objectKey := r.PathValue("objectKey")
 
-	exists, err := storage.BucketExists(h.server.Dir, bucketName)
-	if err != nil {
-		h.sendError(w, "InternalError", "Failed to check bucket existence", http.StatusInternalServerError)
+	bucket, ok := h.getBucket(w, bucketName)
+	if !ok {
 		return
 	}
-	if !exists {
-		h.sendError(w, "NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound)
+
+	if query.Has("versionId") {
+		removed, err := h.storage.DeleteObjectVersion(bucketName, objectKey, query.Get("versionId"))
+		if errors.Is(err, storage.ErrNoSuchVersion) {
+			h.sendError(w, "NoSuchVersion", "The specified version does not exist", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.sendError(w, "InternalError", "Failed to delete object version", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("x-amz-version-id", query.Get("versionId"))
+		if removed.DeleteMarker {
+			w.Header().Set("x-amz-delete-marker", "true")
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if bucket.Versioning != "" {
+		versionID, err := storage.VersionIDFor(bucket)
+		if err == nil {
+			err = h.storage.PutDeleteMarker(bucketName, objectKey, versionID)
+		}
+		if err != nil {
+			h.sendError(w, "InternalError", "Failed to delete object", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("x-amz-delete-marker", "true")
+		w.Header().Set("x-amz-version-id", versionID)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	objectExists, err := storage.ObjectExists(h.server.Dir, bucketName, objectKey)
+	objectExists, err := h.storage.ObjectExists(bucketName, objectKey)
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to check object existence", http.StatusInternalServerError)
 		return
@@ -133,7 +467,7 @@ func (h *Handler) DeleteObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = storage.DeleteObject(h.server.Dir, bucketName, objectKey)
+	err = h.storage.DeleteObject(bucketName, objectKey)
 	if err != nil {
 		h.sendError(w, "InternalError", "Failed to delete object", http.StatusInternalServerError)
 		return
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"triple-s/internal/policy"
+	"triple-s/internal/structure"
+)
+
+func (h *Handler) PutBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("bucketName")
+
+	bucket, ok := h.getBucket(w, bucketName)
+	if !ok {
+		return
+	}
+
+	document, err := io.ReadAll(io.LimitReader(r.Body, policy.MaxSize+1))
+	if h.sendAuthError(w, err) {
+		return
+	}
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to read policy", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = policy.Parse(document, bucketName)
+	if err != nil {
+		h.sendError(w, "MalformedPolicy", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var compact bytes.Buffer
+	err = json.Compact(&compact, document)
+	if err != nil {
+		h.sendError(w, "MalformedPolicy", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ok = h.updateBucket(w, bucket.Name, "Failed to store bucket policy", func(bucket *structure.Bucket) {
+		bucket.Policy = compact.String()
+	})
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	if bucket.Policy == "" {
+		h.sendError(w, "NoSuchBucketPolicy", "The bucket policy does not exist", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	io.WriteString(w, bucket.Policy)
+}
+
+func (h *Handler) DeleteBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	if bucket.Policy != "" {
+		ok = h.updateBucket(w, bucket.Name, "Failed to delete bucket policy", func(bucket *structure.Bucket) {
+			bucket.Policy = ""
+		})
+		if !ok {
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseBucketPolicy returns nil when the bucket has no policy or the stored
+// one can no longer be parsed, in which case only the owner has access.
+func parseBucketPolicy(bucket *structure.Bucket) *policy.Policy {
+	if bucket.Policy == "" {
+		return nil
+	}
+
+	parsed, err := policy.Parse([]byte(bucket.Policy), bucket.Name)
+	if err != nil {
+		return nil
+	}
+	return parsed
+}
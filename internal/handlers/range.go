@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// parseRange interprets a single-range "bytes=" header against an object of
+// the given size. Like S3, malformed or multi-range headers are ignored and
+// the whole object is served, which is reported as a nil range.
+func parseRange(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return nil, nil
+		}
+		if suffix == 0 || size == 0 {
+			return nil, errUnsatisfiableRange
+		}
+		suffix = min(suffix, size)
+		return &byteRange{start: size - suffix, length: suffix}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		end = min(end, size-1)
+	}
+
+	if start >= size {
+		return nil, errUnsatisfiableRange
+	}
+
+	return &byteRange{start: start, length: end - start + 1}, nil
+}
+
+func (br *byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(br.start, 10) + "-" + strconv.FormatInt(br.start+br.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+func (h *Handler) PutBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	var configuration structure.VersioningConfiguration
+	err := xml.NewDecoder(io.LimitReader(r.Body, maxCompleteBodySize)).Decode(&configuration)
+	if h.sendAuthError(w, err) {
+		return
+	}
+	if err != nil || (configuration.Status != storage.VersioningEnabled && configuration.Status != storage.VersioningSuspended) {
+		h.sendError(w, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", http.StatusBadRequest)
+		return
+	}
+
+	ok = h.updateBucket(w, bucket.Name, "Failed to store bucket versioning", func(bucket *structure.Bucket) {
+		bucket.Versioning = configuration.Status
+	})
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(structure.VersioningConfiguration{Status: bucket.Versioning})
+}
+
+func (h *Handler) ListObjectVersions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	maxKeys, ok := parseLimit(query.Get("max-keys"), defaultMaxKeys)
+	if !ok {
+		h.sendError(w, "InvalidArgument", "max-keys must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	bucket, ok := h.getBucket(w, r.PathValue("bucketName"))
+	if !ok {
+		return
+	}
+
+	versions, err := h.storage.ListObjectVersions(bucket.Name)
+	if err != nil {
+		h.sendError(w, "InternalError", "Failed to list object versions", http.StatusInternalServerError)
+		return
+	}
+
+	response := structure.ListVersionsResult{
+		Name:            bucket.Name,
+		Prefix:          query.Get("prefix"),
+		Delimiter:       query.Get("delimiter"),
+		KeyMarker:       query.Get("key-marker"),
+		VersionIDMarker: query.Get("version-id-marker"),
+		MaxKeys:         maxKeys,
+		CommonPrefixes:  []structure.CommonPrefix{},
+	}
+	owner := h.bucketOwner(bucket)
+
+	// Versions are ordered by key and then newest first, so the
+	// version-id-marker only applies within the key named by key-marker.
+	passedMarker := false
+	count := 0
+	last := ""
+	for _, version := range versions {
+		key := version.ObjectKey
+		if !strings.HasPrefix(key, response.Prefix) || key < response.KeyMarker {
+			continue
+		}
+		if response.KeyMarker != "" && key == response.KeyMarker && !passedMarker {
+			if response.VersionIDMarker != "" && version.VersionID == response.VersionIDMarker {
+				passedMarker = true
+			}
+			continue
+		}
+
+		commonPrefix := ""
+		if response.Delimiter != "" {
+			rest := key[len(response.Prefix):]
+			if idx := strings.Index(rest, response.Delimiter); idx >= 0 {
+				commonPrefix = response.Prefix + rest[:idx+len(response.Delimiter)]
+			}
+		}
+		if commonPrefix != "" && (commonPrefix <= response.KeyMarker || commonPrefix == last) {
+			continue
+		}
+
+		if count == maxKeys {
+			response.IsTruncated = maxKeys > 0
+			break
+		}
+		count++
+
+		if commonPrefix != "" {
+			response.CommonPrefixes = append(response.CommonPrefixes, structure.CommonPrefix{Prefix: commonPrefix})
+			response.NextKeyMarker = commonPrefix
+			response.NextVersionIDMarker = ""
+			last = commonPrefix
+			continue
+		}
+
+		if version.DeleteMarker {
+			response.Entries = append(response.Entries, structure.DeleteMarkerEntry{
+				Key:          key,
+				VersionID:    version.VersionID,
+				IsLatest:     version.IsLatest,
+				LastModified: version.LastModified,
+				Owner:        owner,
+			})
+		} else {
+			response.Entries = append(response.Entries, structure.ObjectVersion{
+				Key:          key,
+				VersionID:    version.VersionID,
+				IsLatest:     version.IsLatest,
+				LastModified: version.LastModified,
+				ETag:         quoteETag(version.ETag),
+				Size:         version.Size,
+				StorageClass: "STANDARD",
+				Owner:        owner,
+			})
+		}
+		response.NextKeyMarker = key
+		response.NextVersionIDMarker = version.VersionID
+		last = key
+	}
+
+	if !response.IsTruncated {
+		response.NextKeyMarker = ""
+		response.NextVersionIDMarker = ""
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	xml.NewEncoder(w).Encode(response)
+}
+
+// setVersionHeader reports the version of object for buckets that have had
+// versioning turned on.
+func setVersionHeader(w http.ResponseWriter, object *structure.Object) {
+	if object.VersionID != "" {
+		w.Header().Set("x-amz-version-id", object.VersionID)
+	}
+}
@@ -0,0 +1,276 @@
+package kv
+
+import "sort"
+
+// degree is the minimum degree of the B-tree: every node but the root holds
+// between degree-1 and 2*degree-1 items.
+const (
+	degree   = 32
+	minItems = degree - 1
+	maxItems = 2*degree - 1
+)
+
+type item struct {
+	key   string
+	value []byte
+}
+
+type node struct {
+	items    []item
+	children []*node
+}
+
+type btree struct {
+	root   *node
+	length int
+}
+
+type removal int
+
+const (
+	removeKey removal = iota
+	removeMin
+	removeMax
+)
+
+func (n *node) leaf() bool {
+	return len(n.children) == 0
+}
+
+// find returns the index of the first item not less than key, and whether
+// that item is key itself.
+func (n *node) find(key string) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool {
+		return n.items[i].key >= key
+	})
+	return i, i < len(n.items) && n.items[i].key == key
+}
+
+func (t *btree) get(key string) ([]byte, bool) {
+	n := t.root
+	for n != nil {
+		i, found := n.find(key)
+		if found {
+			return n.items[i].value, true
+		}
+		if n.leaf() {
+			return nil, false
+		}
+		n = n.children[i]
+	}
+	return nil, false
+}
+
+// set stores value under key and returns the value it replaced, if any.
+func (t *btree) set(key string, value []byte) ([]byte, bool) {
+	if t.root == nil {
+		t.root = &node{items: []item{{key, value}}}
+		t.length++
+		return nil, false
+	}
+
+	if len(t.root.items) >= maxItems {
+		root := &node{children: []*node{t.root}}
+		root.splitChild(0)
+		t.root = root
+	}
+
+	old, replaced := t.root.insert(key, value)
+	if !replaced {
+		t.length++
+	}
+	return old, replaced
+}
+
+// insert adds key to the subtree rooted at n, which must not be full.
+// Full children are split on the way down so that a split never has to
+// propagate upwards.
+func (n *node) insert(key string, value []byte) ([]byte, bool) {
+	i, found := n.find(key)
+	if found {
+		old := n.items[i].value
+		n.items[i].value = value
+		return old, true
+	}
+
+	if n.leaf() {
+		n.items = append(n.items, item{})
+		copy(n.items[i+1:], n.items[i:])
+		n.items[i] = item{key, value}
+		return nil, false
+	}
+
+	if len(n.children[i].items) >= maxItems {
+		n.splitChild(i)
+		switch median := n.items[i].key; {
+		case key == median:
+			old := n.items[i].value
+			n.items[i].value = value
+			return old, true
+		case key > median:
+			i++
+		}
+	}
+	return n.children[i].insert(key, value)
+}
+
+// splitChild moves the upper half of the full child i into a new sibling
+// and its median item up into n.
+func (n *node) splitChild(i int) {
+	child := n.children[i]
+	median := child.items[minItems]
+
+	right := &node{items: append([]item(nil), child.items[minItems+1:]...)}
+	if !child.leaf() {
+		right.children = append([]*node(nil), child.children[minItems+1:]...)
+		child.children = child.children[:minItems+1]
+	}
+	child.items = child.items[:minItems]
+
+	n.items = append(n.items, item{})
+	copy(n.items[i+1:], n.items[i:])
+	n.items[i] = median
+
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = right
+}
+
+// delete removes key and returns the value it held, if any.
+func (t *btree) delete(key string) ([]byte, bool) {
+	if t.root == nil {
+		return nil, false
+	}
+
+	removed, ok := t.root.remove(key, removeKey)
+	if len(t.root.items) == 0 {
+		if t.root.leaf() {
+			t.root = nil
+		} else {
+			t.root = t.root.children[0]
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	t.length--
+	return removed.value, true
+}
+
+// remove deletes key, or the smallest or largest item, from the subtree
+// rooted at n. Before descending it makes sure the child it descends into
+// has more than the minimum number of items, so the removal never leaves a
+// node underfull.
+func (n *node) remove(key string, how removal) (item, bool) {
+	var i int
+	var found bool
+	switch how {
+	case removeMin:
+		if n.leaf() {
+			return n.removeAt(0), true
+		}
+	case removeMax:
+		if n.leaf() {
+			return n.removeAt(len(n.items) - 1), true
+		}
+		i = len(n.items)
+	case removeKey:
+		i, found = n.find(key)
+		if n.leaf() {
+			if !found {
+				return item{}, false
+			}
+			return n.removeAt(i), true
+		}
+	}
+
+	if len(n.children[i].items) <= minItems {
+		n.growChild(i)
+		return n.remove(key, how)
+	}
+
+	child := n.children[i]
+	if found {
+		// Replace the item with its predecessor, which lives in a leaf.
+		removed := n.items[i]
+		n.items[i], _ = child.remove("", removeMax)
+		return removed, true
+	}
+	return child.remove(key, how)
+}
+
+func (n *node) removeAt(i int) item {
+	removed := n.items[i]
+	copy(n.items[i:], n.items[i+1:])
+	n.items[len(n.items)-1] = item{}
+	n.items = n.items[:len(n.items)-1]
+	return removed
+}
+
+func (n *node) removeChildAt(i int) *node {
+	removed := n.children[i]
+	copy(n.children[i:], n.children[i+1:])
+	n.children[len(n.children)-1] = nil
+	n.children = n.children[:len(n.children)-1]
+	return removed
+}
+
+// growChild gives child i an extra item, borrowing one through n from a
+// sibling that can spare it or else merging the child with a sibling.
+func (n *node) growChild(i int) {
+	switch {
+	case i > 0 && len(n.children[i-1].items) > minItems:
+		child, left := n.children[i], n.children[i-1]
+
+		child.items = append([]item{n.items[i-1]}, child.items...)
+		n.items[i-1] = left.removeAt(len(left.items) - 1)
+		if !left.leaf() {
+			child.children = append([]*node{left.removeChildAt(len(left.children) - 1)}, child.children...)
+		}
+
+	case i < len(n.items) && len(n.children[i+1].items) > minItems:
+		child, right := n.children[i], n.children[i+1]
+
+		child.items = append(child.items, n.items[i])
+		n.items[i] = right.removeAt(0)
+		if !right.leaf() {
+			child.children = append(child.children, right.removeChildAt(0))
+		}
+
+	default:
+		if i >= len(n.items) {
+			i--
+		}
+		child := n.children[i]
+		separator := n.removeAt(i)
+		right := n.removeChildAt(i + 1)
+
+		child.items = append(child.items, separator)
+		child.items = append(child.items, right.items...)
+		child.children = append(child.children, right.children...)
+	}
+}
+
+// ascend calls fn for every item with a key not less than start, in key
+// order, until fn returns false.
+func (t *btree) ascend(start string, fn func(key string, value []byte) bool) {
+	if t.root != nil {
+		t.root.ascend(start, fn)
+	}
+}
+
+func (n *node) ascend(start string, fn func(key string, value []byte) bool) bool {
+	i, _ := n.find(start)
+	for ; i < len(n.items); i++ {
+		if !n.leaf() && !n.children[i].ascend(start, fn) {
+			return false
+		}
+		if !fn(n.items[i].key, n.items[i].value) {
+			return false
+		}
+	}
+	if !n.leaf() {
+		return n.children[len(n.items)].ascend(start, fn)
+	}
+	return true
+}
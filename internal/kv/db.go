@@ -0,0 +1,465 @@
+// Package kv is a small embedded key-value store. Keys are kept in an
+// in-memory B-tree, so lookups and ordered iteration never touch the disk,
+// and every committed transaction is appended to a log file as a single
+// checksummed record before it becomes visible. On open the log is replayed
+// to rebuild the tree; a record torn by a crash fails its checksum and is
+// discarded together with anything after it, so transactions are atomic.
+// The log is rewritten with only the live keys once it has grown to several
+// times their size.
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	opPut    = 1
+	opDelete = 2
+
+	headerSize = 8
+
+	// compactMinSize and compactRatio decide when the log is rewritten:
+	// once it is larger than both compactMinSize and compactRatio times
+	// the live data.
+	compactMinSize = 4 << 20
+	compactRatio   = 4
+
+	// compactRecordSize caps the payload of each record written during
+	// compaction.
+	compactRecordSize = 1 << 20
+)
+
+var (
+	ErrReadOnly = errors.New("kv: write in a read-only transaction")
+	ErrClosed   = errors.New("kv: database is closed")
+)
+
+// DB is a key-value store backed by a single log file. It is safe for
+// concurrent use. Several processes may share the file as long as they
+// serialize their writes with a lock of their own and call Refresh after
+// taking it.
+type DB struct {
+	mu   sync.RWMutex
+	path string
+	file *os.File
+	size int64
+	live int64
+	tree btree
+}
+
+type op struct {
+	kind  byte
+	key   string
+	value []byte
+}
+
+// Open opens the database at path, creating it if it does not exist, and
+// replays its log.
+func Open(path string) (*DB, error) {
+	db := &DB{path: path}
+	err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// load reads the log from the start into an empty tree and truncates a torn
+// record left at its end by a crash.
+func (db *DB) load() error {
+	file, err := os.OpenFile(db.path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+
+	db.tree = btree{}
+	db.live = 0
+	size, err := db.replay(file, 0)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() > size {
+		err = file.Truncate(size)
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if db.file != nil {
+		db.file.Close()
+	}
+	db.file = file
+	db.size = size
+	return nil
+}
+
+// replay applies the records of file starting at offset and returns the
+// offset just past the last intact record.
+func (db *DB) replay(file *os.File, offset int64) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return offset, err
+	}
+	reader := bufio.NewReader(io.NewSectionReader(file, offset, 1<<62))
+	header := make([]byte, headerSize)
+
+	for {
+		_, err := io.ReadFull(reader, header)
+		if err != nil {
+			return offset, nil
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+
+		// A torn header can claim any length; one that runs past the end
+		// of the file is not allocated.
+		if int64(length) > info.Size()-offset-headerSize {
+			return offset, nil
+		}
+		payload := make([]byte, length)
+		_, err = io.ReadFull(reader, payload)
+		if err != nil || crc32.ChecksumIEEE(payload) != checksum {
+			return offset, nil
+		}
+
+		ops, err := decodeOps(payload)
+		if err != nil {
+			return offset, nil
+		}
+		for _, op := range ops {
+			db.apply(op)
+		}
+		offset += headerSize + int64(length)
+	}
+}
+
+func (db *DB) apply(op op) ([]byte, bool) {
+	var old []byte
+	var existed bool
+	switch op.kind {
+	case opPut:
+		old, existed = db.tree.set(op.key, op.value)
+		db.live += int64(len(op.key) + len(op.value))
+	case opDelete:
+		old, existed = db.tree.delete(op.key)
+	}
+	if existed {
+		db.live -= int64(len(op.key) + len(old))
+	}
+	return old, existed
+}
+
+// Refresh picks up transactions committed by other processes since the log
+// was last read, reloading it entirely if it has been compacted.
+func (db *DB) Refresh() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.file == nil {
+		return ErrClosed
+	}
+
+	current, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+	opened, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !os.SameFile(current, opened) || current.Size() < db.size {
+		return db.load()
+	}
+	if current.Size() > db.size {
+		db.size, err = db.replay(db.file, db.size)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.file == nil {
+		return nil
+	}
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
+
+// Len returns the number of keys in the database.
+func (db *DB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.tree.length
+}
+
+// View runs fn in a read-only transaction.
+func (db *DB) View(fn func(tx *Tx) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.file == nil {
+		return ErrClosed
+	}
+	return fn(&Tx{db: db})
+}
+
+// Update runs fn in a read-write transaction. The writes of fn are visible
+// to fn itself as they are made. If fn returns an error, or the transaction
+// cannot be written to the log, every write is undone and the error is
+// returned; otherwise all of them are committed together.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.file == nil {
+		return ErrClosed
+	}
+
+	tx := &Tx{db: db, writable: true}
+	err := fn(tx)
+	if err == nil && len(tx.ops) > 0 {
+		err = db.append(encodeOps(tx.ops))
+	}
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+
+	if db.size > compactMinSize && db.size > compactRatio*db.live {
+		// The transaction is already durable; a failed compaction only
+		// leaves the log longer than it needs to be.
+		db.compact()
+	}
+	return nil
+}
+
+// append writes one record to the end of the log and syncs it.
+func (db *DB) append(payload []byte) error {
+	record := make([]byte, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[headerSize:], payload)
+
+	_, err := db.file.WriteAt(record, db.size)
+	if err == nil {
+		err = db.file.Sync()
+	}
+	if err != nil {
+		// Cut off whatever part of the record made it to the file so the
+		// next transaction does not follow a torn record.
+		db.file.Truncate(db.size)
+		return err
+	}
+
+	db.size += int64(len(record))
+	return nil
+}
+
+// compact writes the live keys to a new log and renames it over the old
+// one.
+func (db *DB) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// Keep the mode Open gives the log rather than the private one of
+	// CreateTemp.
+	err = tmp.Chmod(0o644)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	compacted := &DB{path: db.path, file: tmp}
+	var batch []op
+	batchSize := 0
+	db.tree.ascend("", func(key string, value []byte) bool {
+		batch = append(batch, op{opPut, key, value})
+		batchSize += len(key) + len(value)
+		if batchSize >= compactRecordSize {
+			err = compacted.append(encodeOps(batch))
+			batch, batchSize = nil, 0
+		}
+		return err == nil
+	})
+	if err == nil && len(batch) > 0 {
+		err = compacted.append(encodeOps(batch))
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), db.path)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	syncDir(filepath.Dir(db.path))
+
+	db.file.Close()
+	db.file = tmp
+	db.size = compacted.size
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Tx is a transaction. It must not be used after the function it was passed
+// to returns.
+type Tx struct {
+	db       *DB
+	writable bool
+	ops      []op
+	undo     []op
+}
+
+// Get returns the value stored under key. The returned slice must not be
+// modified.
+func (tx *Tx) Get(key string) ([]byte, bool) {
+	return tx.db.tree.get(key)
+}
+
+func (tx *Tx) Put(key string, value []byte) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+
+	value = append([]byte(nil), value...)
+	old, existed := tx.db.apply(op{opPut, key, value})
+	tx.record(op{opPut, key, value}, old, existed)
+	return nil
+}
+
+// Delete removes key. Deleting a key that does not exist is not an error.
+func (tx *Tx) Delete(key string) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+
+	old, existed := tx.db.apply(op{kind: opDelete, key: key})
+	if existed {
+		tx.record(op{kind: opDelete, key: key}, old, existed)
+	}
+	return nil
+}
+
+func (tx *Tx) record(done op, old []byte, existed bool) {
+	tx.ops = append(tx.ops, done)
+	if existed {
+		tx.undo = append(tx.undo, op{opPut, done.key, old})
+	} else {
+		tx.undo = append(tx.undo, op{kind: opDelete, key: done.key})
+	}
+}
+
+func (tx *Tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.db.apply(tx.undo[i])
+	}
+	tx.ops, tx.undo = nil, nil
+}
+
+// Scan calls fn for every key with the given prefix in ascending order
+// until fn returns false. fn must not write to the transaction.
+func (tx *Tx) Scan(prefix string, fn func(key string, value []byte) bool) {
+	tx.ScanFrom(prefix, prefix, fn)
+}
+
+// ScanFrom is Scan starting at the first key with the given prefix that is
+// not less than start.
+func (tx *Tx) ScanFrom(prefix, start string, fn func(key string, value []byte) bool) {
+	tx.db.tree.ascend(max(prefix, start), func(key string, value []byte) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		return fn(key, value)
+	})
+}
+
+// encodeOps serializes ops as a sequence of
+// kind, uvarint key length, key[, uvarint value length, value].
+func encodeOps(ops []op) []byte {
+	var payload []byte
+	for _, op := range ops {
+		payload = append(payload, op.kind)
+		payload = binary.AppendUvarint(payload, uint64(len(op.key)))
+		payload = append(payload, op.key...)
+		if op.kind == opPut {
+			payload = binary.AppendUvarint(payload, uint64(len(op.value)))
+			payload = append(payload, op.value...)
+		}
+	}
+	return payload
+}
+
+var errCorrupt = errors.New("kv: corrupt record")
+
+func decodeOps(payload []byte) ([]op, error) {
+	var ops []op
+	for len(payload) > 0 {
+		kind := payload[0]
+		payload = payload[1:]
+		if kind != opPut && kind != opDelete {
+			return nil, errCorrupt
+		}
+
+		key, rest, err := readBytes(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = rest
+
+		decoded := op{kind: kind, key: string(key)}
+		if kind == opPut {
+			decoded.value, payload, err = readBytes(payload)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ops = append(ops, decoded)
+	}
+	return ops, nil
+}
+
+func readBytes(payload []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(payload)
+	if n <= 0 || uint64(len(payload)-n) < length {
+		return nil, nil, errCorrupt
+	}
+	end := n + int(length)
+	return payload[n:end:end], payload[end:], nil
+}
@@ -0,0 +1,327 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func openTestDB(t *testing.T, path string) *DB {
+	t.Helper()
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func put(t *testing.T, db *DB, key, value string) {
+	t.Helper()
+
+	err := db.Update(func(tx *Tx) error {
+		return tx.Put(key, []byte(value))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkContents compares every key and value of db, and a scan of every
+// prefix in prefixes, with want.
+func checkContents(t *testing.T, db *DB, want map[string]string, prefixes []string) {
+	t.Helper()
+
+	keys := make([]string, 0, len(want))
+	for key := range want {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	if db.Len() != len(keys) {
+		t.Errorf("Len() = %d, want %d", db.Len(), len(keys))
+	}
+	err := db.View(func(tx *Tx) error {
+		for key, value := range want {
+			got, ok := tx.Get(key)
+			if !ok || string(got) != value {
+				t.Errorf("Get(%q) = %q, %v; want %q", key, got, ok, value)
+			}
+		}
+
+		for _, prefix := range append(prefixes, "") {
+			var wantKeys, gotKeys []string
+			for _, key := range keys {
+				if strings.HasPrefix(key, prefix) {
+					wantKeys = append(wantKeys, key)
+				}
+			}
+			tx.Scan(prefix, func(key string, value []byte) bool {
+				gotKeys = append(gotKeys, key)
+				if string(value) != want[key] {
+					t.Errorf("Scan(%q): %q = %q, want %q", prefix, key, value, want[key])
+				}
+				return true
+			})
+			if !slices.Equal(gotKeys, wantKeys) {
+				t.Errorf("Scan(%q) = %d keys, want %d", prefix, len(gotKeys), len(wantKeys))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRandomOperations applies random transactions, some of them aborted,
+// and checks the database against a map after each round and after
+// reopening it.
+func TestRandomOperations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+
+	random := rand.New(rand.NewSource(1))
+	want := map[string]string{}
+	randomKey := func() string {
+		return fmt.Sprintf("%c/%04d", 'a'+random.Intn(4), random.Intn(2000))
+	}
+	prefixes := []string{"a", "b/", "c/01", "d/1999", "e"}
+	errAbort := errors.New("abort")
+
+	for round := range 20 {
+		for range 50 {
+			abort := random.Intn(5) == 0
+			written := map[string]*string{}
+			err := db.Update(func(tx *Tx) error {
+				for range 1 + random.Intn(20) {
+					key := randomKey()
+					if random.Intn(3) == 0 {
+						tx.Delete(key)
+						written[key] = nil
+						continue
+					}
+					value := fmt.Sprint(random.Int())
+					tx.Put(key, []byte(value))
+					written[key] = &value
+				}
+				if abort {
+					return errAbort
+				}
+				return nil
+			})
+			if abort {
+				if !errors.Is(err, errAbort) {
+					t.Fatalf("aborted transaction returned %v", err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range written {
+				if value == nil {
+					delete(want, key)
+				} else {
+					want[key] = *value
+				}
+			}
+		}
+		checkContents(t, db, want, prefixes)
+
+		if round%5 == 4 {
+			db.Close()
+			db = openTestDB(t, path)
+			checkContents(t, db, want, prefixes)
+		}
+	}
+}
+
+// TestTornTail cuts the last record of the log short, and appends a header
+// that claims more data than the file holds, and checks that opening the
+// log drops exactly those and truncates them away.
+func TestTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+	put(t, db, "kept", "1")
+	intact := db.size
+	put(t, db, "torn", "2")
+	db.Close()
+
+	tests := []struct {
+		name string
+		tail func(file *os.File) error
+	}{
+		{"short record", func(file *os.File) error {
+			return file.Truncate(intact + headerSize + 1)
+		}},
+		{"huge length", func(file *os.File) error {
+			header := make([]byte, headerSize)
+			binary.LittleEndian.PutUint32(header, 0xffffffff)
+			_, err := file.WriteAt(header, intact)
+			return err
+		}},
+		{"bad checksum", func(file *os.File) error {
+			_, err := file.WriteAt([]byte{0xff}, intact+4)
+			return err
+		}},
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := os.WriteFile(path, content, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = test.tail(file)
+			file.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db := openTestDB(t, path)
+			checkContents(t, db, map[string]string{"kept": "1"}, nil)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != intact {
+				t.Errorf("log is %d bytes, want it truncated to %d", info.Size(), intact)
+			}
+
+			put(t, db, "after", "3")
+			db.Close()
+			db = openTestDB(t, path)
+			checkContents(t, db, map[string]string{"kept": "1", "after": "3"}, nil)
+		})
+	}
+}
+
+// TestFailedAppend makes writing to the log fail and checks that the
+// transaction is undone in memory and leaves nothing in the log.
+func TestFailedAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+	put(t, db, "a", "1")
+	put(t, db, "b", "2")
+	size := db.size
+
+	db.file.Close()
+	readOnly, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.file = readOnly
+
+	err = db.Update(func(tx *Tx) error {
+		tx.Put("a", []byte("changed"))
+		tx.Delete("b")
+		return tx.Put("c", []byte("3"))
+	})
+	if err == nil {
+		t.Fatal("transaction committed to a read-only log")
+	}
+	checkContents(t, db, map[string]string{"a": "1", "b": "2"}, nil)
+	if db.size != size {
+		t.Errorf("log size moved from %d to %d", size, db.size)
+	}
+	db.Close()
+
+	db = openTestDB(t, path)
+	checkContents(t, db, map[string]string{"a": "1", "b": "2"}, nil)
+}
+
+// TestCompaction overwrites a few keys until the log is compacted and checks
+// that only their latest values are left.
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+
+	value := strings.Repeat("v", 64<<10)
+	want := map[string]string{}
+	for i := range 2 * compactMinSize / len(value) {
+		key := fmt.Sprint("key", i%4)
+		want[key] = fmt.Sprint(i) + value
+		put(t, db, key, want[key])
+	}
+	put(t, db, "deleted", "x")
+	err := db.Update(func(tx *Tx) error {
+		return tx.Delete("deleted")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > compactMinSize {
+		t.Errorf("log is %d bytes, want it compacted", info.Size())
+	}
+	checkContents(t, db, want, []string{"key"})
+
+	db.Close()
+	db = openTestDB(t, path)
+	checkContents(t, db, want, []string{"key"})
+	matches, err := filepath.Glob(path + ".compact-*")
+	if err != nil || len(matches) > 0 {
+		t.Errorf("compaction left %v behind (%v)", matches, err)
+	}
+}
+
+// TestRefreshAfterCompaction shares a log between two handles and checks
+// that Refresh follows writes made through the other one, including across
+// a compaction that replaces the file.
+func TestRefreshAfterCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	writer := openTestDB(t, path)
+	reader := openTestDB(t, path)
+
+	put(t, writer, "a", "1")
+	put(t, writer, "b", "2")
+	err := reader.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, reader, map[string]string{"a": "1", "b": "2"}, nil)
+
+	err = writer.Update(func(tx *Tx) error {
+		return tx.Delete("a")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, writer, "c", "3")
+
+	err = reader.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, reader, map[string]string{"b": "2", "c": "3"}, nil)
+
+	put(t, reader, "d", "4")
+	err = writer.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, writer, map[string]string{"b": "2", "c": "3", "d": "4"}, nil)
+}
@@ -0,0 +1,231 @@
+package lifecycle
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"triple-s/internal/structure"
+)
+
+const (
+	MaxSize  = 64 << 10
+	maxRules = 1000
+
+	StatusEnabled  = "Enabled"
+	StatusDisabled = "Disabled"
+)
+
+var ErrMalformed = errors.New("malformed lifecycle configuration")
+
+// Parse decodes a LifecycleConfiguration document and checks that every rule
+// is well formed. Decoding failures wrap ErrMalformed; the other errors
+// describe the invalid rule.
+func Parse(document []byte) (*structure.LifecycleConfiguration, error) {
+	var configuration structure.LifecycleConfiguration
+	err := xml.NewDecoder(bytes.NewReader(document)).Decode(&configuration)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	if len(configuration.Rules) == 0 {
+		return nil, fmt.Errorf("%w: at least one rule is required", ErrMalformed)
+	}
+	if len(configuration.Rules) > maxRules {
+		return nil, fmt.Errorf("a lifecycle configuration can have at most %d rules", maxRules)
+	}
+
+	ids := make(map[string]bool)
+	for i := range configuration.Rules {
+		rule := &configuration.Rules[i]
+		if rule.ID != "" {
+			if ids[rule.ID] {
+				return nil, fmt.Errorf("rule ID %q is not unique", rule.ID)
+			}
+			ids[rule.ID] = true
+		}
+
+		err = validateRule(rule)
+		if err != nil {
+			if rule.ID != "" {
+				return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+			}
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+
+	return &configuration, nil
+}
+
+func validateRule(rule *structure.LifecycleRule) error {
+	if len(rule.ID) > 255 {
+		return errors.New("ID must be at most 255 characters")
+	}
+	if rule.Status != StatusEnabled && rule.Status != StatusDisabled {
+		return fmt.Errorf("invalid status %q", rule.Status)
+	}
+
+	if rule.Prefix != nil && rule.Filter != nil {
+		return errors.New("Prefix and Filter cannot both be specified")
+	}
+	if rule.Filter != nil {
+		err := validateFilter(rule.Filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	if rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil && rule.AbortIncompleteMultipartUpload == nil {
+		return errors.New("at least one action is required")
+	}
+
+	if expiration := rule.Expiration; expiration != nil {
+		switch {
+		case expiration.Days != 0 && expiration.Date != "":
+			return errors.New("Expiration cannot specify both Days and Date")
+		case expiration.Date != "":
+			date, err := time.Parse(time.RFC3339, expiration.Date)
+			if err != nil {
+				return fmt.Errorf("invalid Expiration date %q", expiration.Date)
+			}
+			if !date.Equal(midnight(date)) {
+				return errors.New("Expiration date must be at midnight UTC")
+			}
+		case expiration.Days <= 0:
+			return errors.New("Expiration days must be a positive integer")
+		}
+	}
+
+	if noncurrent := rule.NoncurrentVersionExpiration; noncurrent != nil && noncurrent.NoncurrentDays <= 0 {
+		return errors.New("NoncurrentDays must be a positive integer")
+	}
+
+	if abort := rule.AbortIncompleteMultipartUpload; abort != nil {
+		if abort.DaysAfterInitiation <= 0 {
+			return errors.New("DaysAfterInitiation must be a positive integer")
+		}
+		if len(ruleTags(rule)) > 0 {
+			return errors.New("AbortIncompleteMultipartUpload cannot be combined with a tag filter")
+		}
+	}
+
+	return nil
+}
+
+func validateFilter(filter *structure.LifecycleFilter) error {
+	set := 0
+	if filter.Prefix != nil {
+		set++
+	}
+	if filter.Tag != nil {
+		set++
+	}
+	if filter.And != nil {
+		set++
+	}
+	if set > 1 {
+		return errors.New("Filter must contain only one of Prefix, Tag or And")
+	}
+
+	tags := []structure.Tag{}
+	if filter.Tag != nil {
+		tags = append(tags, *filter.Tag)
+	}
+	if filter.And != nil {
+		tags = append(tags, filter.And.Tags...)
+	}
+
+	keys := make(map[string]bool)
+	for _, tag := range tags {
+		if tag.Key == "" {
+			return errors.New("tag keys cannot be empty")
+		}
+		if keys[tag.Key] {
+			return fmt.Errorf("duplicate tag key %q", tag.Key)
+		}
+		keys[tag.Key] = true
+	}
+	return nil
+}
+
+// rulePrefix is the key prefix a rule applies to, given either as the
+// legacy top-level Prefix or within its Filter.
+func rulePrefix(rule *structure.LifecycleRule) string {
+	switch {
+	case rule.Prefix != nil:
+		return *rule.Prefix
+	case rule.Filter == nil:
+		return ""
+	case rule.Filter.Prefix != nil:
+		return *rule.Filter.Prefix
+	case rule.Filter.And != nil:
+		return rule.Filter.And.Prefix
+	}
+	return ""
+}
+
+func ruleTags(rule *structure.LifecycleRule) []structure.Tag {
+	switch {
+	case rule.Filter == nil:
+		return nil
+	case rule.Filter.Tag != nil:
+		return []structure.Tag{*rule.Filter.Tag}
+	case rule.Filter.And != nil:
+		return rule.Filter.And.Tags
+	}
+	return nil
+}
+
+// matches reports whether rule applies to an object with the given key and
+// URL-encoded tag set.
+func matches(rule *structure.LifecycleRule, key, tagging string) bool {
+	if !strings.HasPrefix(key, rulePrefix(rule)) {
+		return false
+	}
+
+	tags := ruleTags(rule)
+	if len(tags) == 0 {
+		return true
+	}
+
+	objectTags, err := url.ParseQuery(tagging)
+	if err != nil {
+		return false
+	}
+	for _, tag := range tags {
+		if !objectTags.Has(tag.Key) || objectTags.Get(tag.Key) != tag.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// expiresAt adds days to t and rounds up to the next midnight UTC, which is
+// when S3 considers an object to have reached that age.
+func expiresAt(t time.Time, days int) time.Time {
+	due := t.UTC().AddDate(0, 0, days)
+	rounded := midnight(due)
+	if rounded.Before(due) {
+		rounded = rounded.AddDate(0, 0, 1)
+	}
+	return rounded
+}
+
+func midnight(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// expired reports whether an object last modified at lastModified is due
+// for expiration under expiration at now.
+func expired(expiration *structure.LifecycleExpiration, lastModified, now time.Time) bool {
+	if expiration.Date != "" {
+		date, err := time.Parse(time.RFC3339, expiration.Date)
+		return err == nil && !now.Before(date)
+	}
+	return !now.Before(expiresAt(lastModified, expiration.Days))
+}
@@ -0,0 +1,184 @@
+package lifecycle
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"triple-s/internal/storage"
+	"triple-s/internal/structure"
+)
+
+// Worker periodically applies the lifecycle configuration of every bucket.
+// In dry-run mode it only logs the actions it would take.
+type Worker struct {
+	storage  storage.Backend
+	interval time.Duration
+	dryRun   bool
+}
+
+func NewWorker(backend storage.Backend, interval time.Duration, dryRun bool) *Worker {
+	return &Worker{
+		storage:  backend,
+		interval: interval,
+		dryRun:   dryRun,
+	}
+}
+
+// Run applies the lifecycle rules once immediately and then every interval.
+// It never returns.
+func (w *Worker) Run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.RunOnce(time.Now())
+		<-ticker.C
+	}
+}
+
+// RunOnce applies the lifecycle rules of every bucket as of now.
+func (w *Worker) RunOnce(now time.Time) {
+	buckets, err := w.storage.ListBuckets()
+	if err != nil {
+		log.Printf("lifecycle: failed to list buckets: %v", err)
+		return
+	}
+
+	for i := range buckets {
+		bucket := &buckets[i]
+		if bucket.Lifecycle == "" {
+			continue
+		}
+
+		configuration, err := Parse([]byte(bucket.Lifecycle))
+		if err != nil {
+			log.Printf("lifecycle: ignoring invalid configuration of bucket %s: %v", bucket.Name, err)
+			continue
+		}
+
+		rules := []*structure.LifecycleRule{}
+		for j := range configuration.Rules {
+			if configuration.Rules[j].Status == StatusEnabled {
+				rules = append(rules, &configuration.Rules[j])
+			}
+		}
+		if len(rules) == 0 {
+			continue
+		}
+
+		w.abortUploads(bucket, rules, now)
+		w.expireObjects(bucket, rules, now)
+		w.expireNoncurrentVersions(bucket, rules, now)
+	}
+}
+
+func (w *Worker) abortUploads(bucket *structure.Bucket, rules []*structure.LifecycleRule, now time.Time) {
+	uploads, err := w.storage.ListMultipartUploads(bucket.Name)
+	if err != nil {
+		log.Printf("lifecycle: failed to list uploads of bucket %s: %v", bucket.Name, err)
+		return
+	}
+
+	for _, upload := range uploads {
+		for _, rule := range rules {
+			abort := rule.AbortIncompleteMultipartUpload
+			if abort == nil || !matches(rule, upload.Key, "") || now.Before(expiresAt(upload.Initiated, abort.DaysAfterInitiation)) {
+				continue
+			}
+
+			w.apply(rule, "abort upload %s of %s/%s", upload.UploadID, bucket.Name, upload.Key)
+			if !w.dryRun {
+				err = w.storage.AbortMultipartUpload(bucket.Name, upload.Key, upload.UploadID)
+				if err != nil && !errors.Is(err, storage.ErrNoSuchUpload) {
+					log.Printf("lifecycle: failed to abort upload %s: %v", upload.UploadID, err)
+				}
+			}
+			break
+		}
+	}
+}
+
+// expireObjects deletes current objects that reached their expiration. In a
+// bucket with versioning turned on a delete marker is added instead, as a
+// DELETE request would.
+func (w *Worker) expireObjects(bucket *structure.Bucket, rules []*structure.LifecycleRule, now time.Time) {
+	objects, err := w.storage.ListObjects(bucket.Name)
+	if err != nil {
+		log.Printf("lifecycle: failed to list objects of bucket %s: %v", bucket.Name, err)
+		return
+	}
+
+	for _, object := range objects {
+		for _, rule := range rules {
+			if rule.Expiration == nil || !matches(rule, object.ObjectKey, object.Tagging) || !expired(rule.Expiration, object.LastModified, now) {
+				continue
+			}
+
+			w.apply(rule, "expire %s/%s", bucket.Name, object.ObjectKey)
+			if !w.dryRun {
+				err = w.expire(bucket, object)
+				if err != nil && !errors.Is(err, storage.ErrObjectChanged) {
+					log.Printf("lifecycle: failed to expire %s/%s: %v", bucket.Name, object.ObjectKey, err)
+				}
+			}
+			break
+		}
+	}
+}
+
+// expire removes object, unless it has been overwritten since it was
+// listed and so is no longer the version that expired.
+func (w *Worker) expire(bucket *structure.Bucket, object structure.Object) error {
+	versionID, err := storage.VersionIDFor(bucket)
+	if err != nil {
+		return err
+	}
+	return w.storage.ExpireObject(bucket.Name, object, versionID)
+}
+
+// expireNoncurrentVersions permanently deletes versions that have been
+// noncurrent for long enough. A version becomes noncurrent when the next
+// newer version of its key is written.
+func (w *Worker) expireNoncurrentVersions(bucket *structure.Bucket, rules []*structure.LifecycleRule, now time.Time) {
+	versions, err := w.storage.ListObjectVersions(bucket.Name)
+	if err != nil {
+		log.Printf("lifecycle: failed to list versions of bucket %s: %v", bucket.Name, err)
+		return
+	}
+
+	for i, version := range versions {
+		if version.IsLatest {
+			continue
+		}
+		noncurrentSince := versions[i-1].LastModified
+
+		for _, rule := range rules {
+			noncurrent := rule.NoncurrentVersionExpiration
+			if noncurrent == nil || !matches(rule, version.ObjectKey, version.Tagging) || now.Before(expiresAt(noncurrentSince, noncurrent.NoncurrentDays)) {
+				continue
+			}
+
+			w.apply(rule, "delete version %s of %s/%s", version.VersionID, bucket.Name, version.ObjectKey)
+			if !w.dryRun {
+				_, err = w.storage.DeleteObjectVersion(bucket.Name, version.ObjectKey, version.VersionID)
+				if err != nil && !errors.Is(err, storage.ErrNoSuchVersion) {
+					log.Printf("lifecycle: failed to delete version %s of %s/%s: %v", version.VersionID, bucket.Name, version.ObjectKey, err)
+				}
+			}
+			break
+		}
+	}
+}
+
+func (w *Worker) apply(rule *structure.LifecycleRule, format string, args ...any) {
+	prefix := "lifecycle: "
+	if w.dryRun {
+		prefix = "lifecycle (dry run): "
+	}
+	if rule.ID != "" {
+		args = append(args, rule.ID)
+		format += " (rule %s)"
+	}
+	log.Printf(prefix+format, args...)
+}
@@ -0,0 +1,62 @@
+package policy
+
+// Canned ACLs accepted in the x-amz-acl header. An empty ACL is private.
+const (
+	ACLPrivate           = "private"
+	ACLPublicRead        = "public-read"
+	ACLPublicReadWrite   = "public-read-write"
+	ACLAuthenticatedRead = "authenticated-read"
+)
+
+const (
+	PermissionRead        = "READ"
+	PermissionWrite       = "WRITE"
+	PermissionFullControl = "FULL_CONTROL"
+)
+
+const (
+	AllUsersGroup           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	AuthenticatedUsersGroup = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+)
+
+// GroupGrant grants permission to every member of a predefined group.
+type GroupGrant struct {
+	Group      string
+	Permission string
+}
+
+func ValidCannedACL(acl string) bool {
+	switch acl {
+	case ACLPrivate, ACLPublicRead, ACLPublicReadWrite, ACLAuthenticatedRead:
+		return true
+	}
+	return false
+}
+
+// CannedGrants lists the group grants implied by acl, in addition to the
+// owner's FULL_CONTROL which every canned ACL includes.
+func CannedGrants(acl string) []GroupGrant {
+	switch acl {
+	case ACLPublicRead:
+		return []GroupGrant{{AllUsersGroup, PermissionRead}}
+	case ACLPublicReadWrite:
+		return []GroupGrant{{AllUsersGroup, PermissionRead}, {AllUsersGroup, PermissionWrite}}
+	case ACLAuthenticatedRead:
+		return []GroupGrant{{AuthenticatedUsersGroup, PermissionRead}}
+	}
+	return nil
+}
+
+// ACLAllows reports whether acl grants permission to a caller who is not
+// the owner.
+func ACLAllows(acl, permission string, authenticated bool) bool {
+	for _, grant := range CannedGrants(acl) {
+		if grant.Permission != permission {
+			continue
+		}
+		if grant.Group == AllUsersGroup || (grant.Group == AuthenticatedUsersGroup && authenticated) {
+			return true
+		}
+	}
+	return false
+}
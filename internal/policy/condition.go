@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+type operator func(value *string, expected []string) bool
+
+var operators = map[string]operator{
+	"StringEquals":    stringEquals,
+	"StringNotEquals": negate(stringEquals),
+	"StringLike":      stringLike,
+	"StringNotLike":   negate(stringLike),
+	"IpAddress":       ipAddress,
+	"NotIpAddress":    negate(ipAddress),
+	"Bool":            boolEquals,
+}
+
+// conditionKeys lists the supported keys in lower case, since condition
+// keys are case-insensitive.
+var conditionKeys = map[string]struct{}{
+	"aws:sourceip":        {},
+	"aws:securetransport": {},
+	"aws:username":        {},
+	"s3:prefix":           {},
+}
+
+// conditionValue returns the value of key for request, or nil if the key is
+// not present in this request.
+func conditionValue(request Request, key string) *string {
+	switch strings.ToLower(key) {
+	case "aws:sourceip":
+		return &request.SourceIP
+	case "aws:securetransport":
+		secure := strconv.FormatBool(request.Secure)
+		return &secure
+	case "aws:username":
+		if request.Principal == "" {
+			return nil
+		}
+		return &request.Principal
+	case "s3:prefix":
+		return request.Prefix
+	}
+	return nil
+}
+
+func negate(op operator) operator {
+	return func(value *string, expected []string) bool {
+		return !op(value, expected)
+	}
+}
+
+func stringEquals(value *string, expected []string) bool {
+	if value == nil {
+		return false
+	}
+	for _, e := range expected {
+		if *value == e {
+			return true
+		}
+	}
+	return false
+}
+
+func stringLike(value *string, expected []string) bool {
+	if value == nil {
+		return false
+	}
+	for _, e := range expected {
+		if wildcardMatch(e, *value) {
+			return true
+		}
+	}
+	return false
+}
+
+func ipAddress(value *string, expected []string) bool {
+	if value == nil {
+		return false
+	}
+	ip := net.ParseIP(*value)
+	if ip == nil {
+		return false
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(e, "/") {
+			if other := net.ParseIP(e); other != nil && other.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		_, network, err := net.ParseCIDR(e)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func boolEquals(value *string, expected []string) bool {
+	if value == nil {
+		return false
+	}
+	for _, e := range expected {
+		if strings.EqualFold(*value, e) {
+			return true
+		}
+	}
+	return false
+}
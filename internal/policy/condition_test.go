@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"fmt"
+	"testing"
+)
+
+// conditionPolicy allows anonymous reads under condition, given as the
+// JSON of a Condition block.
+func conditionPolicy(t *testing.T, condition string) *Policy {
+	t.Helper()
+
+	return mustParse(t, fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Principal": "*",
+			"Action": ["s3:GetObject", "s3:ListBucket"],
+			"Resource": ["arn:aws:s3:::bucket", "arn:aws:s3:::bucket/*"],
+			"Condition": %s
+		}]
+	}`, condition))
+}
+
+func TestSourceIPCondition(t *testing.T) {
+	policy := conditionPolicy(t, `{
+		"IpAddress": {"aws:SourceIp": ["192.0.2.0/24", "203.0.113.7", "2001:db8::/32"]},
+		"NotIpAddress": {"aws:sourceip": ["192.0.2.128/25", "2001:db8:bad::/48"]}
+	}`)
+
+	tests := []struct {
+		ip   string
+		want Decision
+	}{
+		{"192.0.2.1", Allow},
+		{"192.0.2.127", Allow},
+		{"192.0.2.128", NotApplicable},
+		{"192.0.3.1", NotApplicable},
+		{"203.0.113.7", Allow},
+		{"203.0.113.8", NotApplicable},
+		{"::ffff:192.0.2.1", Allow},
+		{"2001:db8::1", Allow},
+		{"2001:0db8:ffff::1", Allow},
+		{"2001:db8:bad::1", NotApplicable},
+		{"2001:db9::1", NotApplicable},
+		{"not an address", NotApplicable},
+		{"", NotApplicable},
+	}
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			request := Request{Action: "s3:GetObject", Resource: "arn:aws:s3:::bucket/key", SourceIP: test.ip}
+			got := policy.Evaluate(request)
+			if got != test.want {
+				t.Errorf("Evaluate from %q = %v, want %v", test.ip, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPrefixCondition(t *testing.T) {
+	policy := conditionPolicy(t, `{
+		"StringLike": {"s3:prefix": ["home/", "home/*", "shared/?/*"]},
+		"StringNotLike": {"s3:prefix": "home/secret*"}
+	}`)
+	prefix := func(s string) *string { return &s }
+
+	tests := []struct {
+		name   string
+		prefix *string
+		want   Decision
+	}{
+		{"exact", prefix("home/"), Allow},
+		{"below", prefix("home/user/docs"), Allow},
+		{"excluded", prefix("home/secret/x"), NotApplicable},
+		{"single character", prefix("shared/a/x"), Allow},
+		{"two characters", prefix("shared/ab/x"), NotApplicable},
+		{"outside", prefix("homes/"), NotApplicable},
+		{"empty", prefix(""), NotApplicable},
+		{"absent", nil, NotApplicable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := Request{Action: "s3:ListBucket", Resource: "arn:aws:s3:::bucket", Prefix: test.prefix}
+			got := policy.Evaluate(request)
+			if got != test.want {
+				t.Errorf("Evaluate() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSecureTransportCondition(t *testing.T) {
+	policy := mustParse(t, `{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Deny",
+			"Principal": "*",
+			"Action": "s3:*",
+			"Resource": "arn:aws:s3:::bucket/*",
+			"Condition": {"Bool": {"aws:SecureTransport": "false"}}
+		}]
+	}`)
+
+	for secure, want := range map[bool]Decision{false: Deny, true: NotApplicable} {
+		got := policy.Evaluate(Request{Principal: "alice", Action: "s3:GetObject", Resource: "arn:aws:s3:::bucket/key", Secure: secure})
+		if got != want {
+			t.Errorf("secure %v: Evaluate() = %v, want %v", secure, got, want)
+		}
+	}
+}
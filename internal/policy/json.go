@@ -0,0 +1,85 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// stringList accepts either a single JSON string or an array of strings,
+// as policy documents use both forms interchangeably.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*l = stringList{single}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(data, &list)
+	if err != nil {
+		return errors.New("expected a string or a list of strings")
+	}
+	*l = list
+	return nil
+}
+
+func (l stringList) MarshalJSON() ([]byte, error) {
+	if len(l) == 1 {
+		return json.Marshal(l[0])
+	}
+	return json.Marshal([]string(l))
+}
+
+// Principal lists the users a statement applies to. "*" matches everyone,
+// including anonymous callers; other entries name a user ID, either bare or
+// as an IAM user ARN.
+type Principal []string
+
+func (p *Principal) UnmarshalJSON(data []byte) error {
+	var wildcard string
+	if json.Unmarshal(data, &wildcard) == nil {
+		if wildcard != "*" {
+			return errors.New(`principal must be "*" or an object`)
+		}
+		*p = Principal{"*"}
+		return nil
+	}
+
+	var principals map[string]stringList
+	err := json.Unmarshal(data, &principals)
+	if err != nil {
+		return errors.New(`principal must be "*" or an object`)
+	}
+	for kind, ids := range principals {
+		if kind != "AWS" {
+			return errors.New("unsupported principal type " + kind)
+		}
+		*p = append(*p, ids...)
+	}
+	return nil
+}
+
+func (p Principal) MarshalJSON() ([]byte, error) {
+	if len(p) == 1 && p[0] == "*" {
+		return json.Marshal("*")
+	}
+	return json.Marshal(map[string]stringList{"AWS": stringList(p)})
+}
+
+func (p Principal) matches(userID string) bool {
+	for _, principal := range p {
+		if principal == "*" {
+			return true
+		}
+		if userID == "" {
+			continue
+		}
+		if principal == userID || strings.HasSuffix(principal, ":user/"+userID) {
+			return true
+		}
+	}
+	return false
+}
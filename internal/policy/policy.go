@@ -0,0 +1,206 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const MaxSize = 20 << 10
+
+var ErrMalformed = errors.New("malformed policy")
+
+const (
+	EffectAllow = "Allow"
+	EffectDeny  = "Deny"
+)
+
+// Decision is the outcome of evaluating a policy against a request.
+type Decision int
+
+const (
+	NotApplicable Decision = iota
+	Allow
+	Deny
+)
+
+type Policy struct {
+	Version   string      `json:"Version"`
+	ID        string      `json:"Id,omitempty"`
+	Statement []Statement `json:"Statement"`
+}
+
+type Statement struct {
+	Sid       string                `json:"Sid,omitempty"`
+	Effect    string                `json:"Effect"`
+	Principal Principal             `json:"Principal"`
+	Action    stringList            `json:"Action"`
+	Resource  stringList            `json:"Resource"`
+	Condition map[string]conditions `json:"Condition,omitempty"`
+}
+
+// conditions maps a condition key such as aws:SourceIp to the values it is
+// compared against.
+type conditions map[string]stringList
+
+// Request describes the access being checked. An empty Principal is an
+// anonymous caller.
+type Request struct {
+	Principal string
+	Action    string
+	Resource  string
+	SourceIP  string
+	Prefix    *string
+	Secure    bool
+}
+
+// Parse decodes a bucket policy document and checks that every statement
+// is well formed and only refers to bucketName.
+func Parse(document []byte, bucketName string) (*Policy, error) {
+	if len(document) > MaxSize {
+		return nil, fmt.Errorf("%w: policy exceeds %d bytes", ErrMalformed, MaxSize)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.DisallowUnknownFields()
+
+	var policy Policy
+	err := decoder.Decode(&policy)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	if policy.Version != "2012-10-17" && policy.Version != "2008-10-17" {
+		return nil, fmt.Errorf("%w: unsupported policy version %q", ErrMalformed, policy.Version)
+	}
+	if len(policy.Statement) == 0 {
+		return nil, fmt.Errorf("%w: policy has no statements", ErrMalformed)
+	}
+
+	for i, statement := range policy.Statement {
+		err = statement.validate(bucketName)
+		if err != nil {
+			return nil, fmt.Errorf("%w: statement %d: %v", ErrMalformed, i+1, err)
+		}
+	}
+
+	return &policy, nil
+}
+
+func (s *Statement) validate(bucketName string) error {
+	if s.Effect != EffectAllow && s.Effect != EffectDeny {
+		return fmt.Errorf("invalid effect %q", s.Effect)
+	}
+	if len(s.Principal) == 0 {
+		return errors.New("missing principal")
+	}
+	if len(s.Action) == 0 {
+		return errors.New("missing action")
+	}
+	for _, action := range s.Action {
+		if action != "*" && !strings.HasPrefix(strings.ToLower(action), "s3:") {
+			return fmt.Errorf("invalid action %q", action)
+		}
+	}
+
+	if len(s.Resource) == 0 {
+		return errors.New("missing resource")
+	}
+	bucketARN := "arn:aws:s3:::" + bucketName
+	for _, resource := range s.Resource {
+		if resource != bucketARN && !strings.HasPrefix(resource, bucketARN+"/") {
+			return fmt.Errorf("resource %q is outside bucket %s", resource, bucketName)
+		}
+	}
+
+	for operator, values := range s.Condition {
+		if _, ok := operators[operator]; !ok {
+			return fmt.Errorf("unsupported condition operator %q", operator)
+		}
+		for key := range values {
+			if _, ok := conditionKeys[strings.ToLower(key)]; !ok {
+				return fmt.Errorf("unsupported condition key %q", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Evaluate returns Deny if any matching statement denies the request,
+// Allow if at least one allows it and NotApplicable otherwise.
+func (p *Policy) Evaluate(request Request) Decision {
+	decision := NotApplicable
+	for _, statement := range p.Statement {
+		if !statement.matches(request) {
+			continue
+		}
+		if statement.Effect == EffectDeny {
+			return Deny
+		}
+		decision = Allow
+	}
+	return decision
+}
+
+func (s *Statement) matches(request Request) bool {
+	if !s.Principal.matches(request.Principal) {
+		return false
+	}
+	if !matchAny(s.Action, request.Action, true) {
+		return false
+	}
+	if !matchAny(s.Resource, request.Resource, false) {
+		return false
+	}
+
+	for operator, values := range s.Condition {
+		for key, expected := range values {
+			if !operators[operator](conditionValue(request, key), expected) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchAny(patterns []string, value string, foldCase bool) bool {
+	for _, pattern := range patterns {
+		if foldCase {
+			pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+		}
+		if wildcardMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch reports whether value matches pattern, where '*' matches
+// any run of characters and '?' matches exactly one.
+func wildcardMatch(pattern, value string) bool {
+	p, v := 0, 0
+	star, match := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, v
+			p++
+		case star >= 0:
+			p = star + 1
+			match++
+			v = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
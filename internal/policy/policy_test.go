@@ -0,0 +1,98 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+)
+
+func mustParse(t *testing.T, document string) *Policy {
+	t.Helper()
+
+	policy, err := Parse([]byte(document), "bucket")
+	if err != nil {
+		t.Fatalf("Parse(%s): %v", document, err)
+	}
+	return policy
+}
+
+func TestEvaluate(t *testing.T) {
+	const document = `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{
+				"Effect": "Allow",
+				"Principal": "*",
+				"Action": "s3:GetObject",
+				"Resource": "arn:aws:s3:::bucket/public/*"
+			},
+			{
+				"Effect": "Allow",
+				"Principal": {"AWS": ["alice", "arn:aws:iam::123456789012:user/bob"]},
+				"Action": ["s3:*"],
+				"Resource": ["arn:aws:s3:::bucket", "arn:aws:s3:::bucket/*"]
+			},
+			{
+				"Effect": "Deny",
+				"Principal": {"AWS": "*"},
+				"Action": ["s3:DeleteObject", "s3:PutObject"],
+				"Resource": "arn:aws:s3:::bucket/public/locked-?"
+			}
+		]
+	}`
+	policy := mustParse(t, document)
+
+	tests := []struct {
+		name    string
+		request Request
+		want    Decision
+	}{
+		{"anonymous read of public key", Request{Action: "s3:GetObject", Resource: "arn:aws:s3:::bucket/public/a"}, Allow},
+		{"anonymous read elsewhere", Request{Action: "s3:GetObject", Resource: "arn:aws:s3:::bucket/private/a"}, NotApplicable},
+		{"anonymous write of public key", Request{Action: "s3:PutObject", Resource: "arn:aws:s3:::bucket/public/a"}, NotApplicable},
+		{"named user", Request{Principal: "alice", Action: "s3:PutObject", Resource: "arn:aws:s3:::bucket/private/a"}, Allow},
+		{"user ARN", Request{Principal: "bob", Action: "s3:ListBucket", Resource: "arn:aws:s3:::bucket"}, Allow},
+		{"action case", Request{Principal: "bob", Action: "S3:LISTBUCKET", Resource: "arn:aws:s3:::bucket"}, Allow},
+		{"other user", Request{Principal: "carol", Action: "s3:PutObject", Resource: "arn:aws:s3:::bucket/private/a"}, NotApplicable},
+		{"deny beats allow", Request{Principal: "alice", Action: "s3:DeleteObject", Resource: "arn:aws:s3:::bucket/public/locked-1"}, Deny},
+		{"deny matches anonymous", Request{Action: "s3:PutObject", Resource: "arn:aws:s3:::bucket/public/locked-2"}, Deny},
+		{"deny pattern length", Request{Principal: "alice", Action: "s3:DeleteObject", Resource: "arn:aws:s3:::bucket/public/locked-10"}, Allow},
+		{"resource case", Request{Principal: "alice", Action: "s3:GetObject", Resource: "arn:aws:s3:::BUCKET/a"}, NotApplicable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := policy.Evaluate(test.request)
+			if got != test.want {
+				t.Errorf("Evaluate(%+v) = %v, want %v", test.request, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"not JSON", `{"Version": "2012-10-17",`},
+		{"unknown field", `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*", "NotAction": "s3:PutObject"}]}`},
+		{"unknown version", `{"Version": "2020-01-01", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}]}`},
+		{"no statements", `{"Version": "2012-10-17", "Statement": []}`},
+		{"bad effect", `{"Version": "2012-10-17", "Statement": [{"Effect": "Maybe", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}]}`},
+		{"bad principal", `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "alice", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}]}`},
+		{"service principal", `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": {"Service": "s3.amazonaws.com"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}]}`},
+		{"other service action", `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "iam:PassRole", "Resource": "arn:aws:s3:::bucket/*"}]}`},
+		{"other bucket", `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket-2/*"}]}`},
+		{"unknown operator", `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*",
+			"Condition": {"NumericLessThan": {"aws:SourceIp": "10"}}}]}`},
+		{"unknown condition key", `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*",
+			"Condition": {"StringEquals": {"aws:Referer": "example.com"}}}]}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse([]byte(test.document), "bucket")
+			if !errors.Is(err, ErrMalformed) {
+				t.Errorf("Parse() = %v, want %v", err, ErrMalformed)
+			}
+		})
+	}
+}
@@ -3,20 +3,27 @@ package router
 import (
 	"net/http"
 
+	"triple-s/internal/auth"
 	h "triple-s/internal/handlers"
+	"triple-s/internal/storage"
 	s "triple-s/internal/structure"
 )
 
-func Router(server *s.Server) *http.ServeMux {
+func Router(server *s.Server, backend storage.Backend, credentials *auth.Store) http.Handler {
 	mux := http.NewServeMux()
-	handler := h.NewHandler(server)
+	handler := h.NewHandler(server, backend, credentials)
 
-	mux.HandleFunc("PUT /{bucketName}", handler.PutBucket)
-	mux.HandleFunc("GET /{$}", handler.GetBuckets)
-	mux.HandleFunc("DELETE /{bucketName}", handler.DeleteBucket)
-	mux.HandleFunc("PUT /{bucketName}/{objectKey}", handler.PutObject)
-	mux.HandleFunc("GET /{bucketName}/{objectKey}", handler.GetObject)
-	mux.HandleFunc("DELETE /{bucketName}/{objectKey}", handler.DeleteObject)
+	mux.HandleFunc("PUT /{bucketName}", handler.Authorize(handler.PutBucket))
+	mux.HandleFunc("GET /{$}", handler.Authorize(handler.GetBuckets))
+	mux.HandleFunc("GET /{bucketName}", handler.Authorize(handler.ListObjects))
+	mux.HandleFunc("HEAD /{bucketName}", handler.Authorize(handler.HeadBucket))
+	mux.HandleFunc("DELETE /{bucketName}", handler.Authorize(handler.DeleteBucket))
+	mux.HandleFunc("POST /{bucketName}", handler.Authorize(handler.PostBucket))
+	mux.HandleFunc("PUT /{bucketName}/{objectKey...}", handler.ValidateKey(handler.Authorize(handler.PutObject)))
+	mux.HandleFunc("GET /{bucketName}/{objectKey...}", handler.ValidateKey(handler.Authorize(handler.GetObject)))
+	mux.HandleFunc("HEAD /{bucketName}/{objectKey...}", handler.ValidateKey(handler.Authorize(handler.HeadObject)))
+	mux.HandleFunc("DELETE /{bucketName}/{objectKey...}", handler.ValidateKey(handler.Authorize(handler.DeleteObject)))
+	mux.HandleFunc("POST /{bucketName}/{objectKey...}", handler.ValidateKey(handler.Authorize(handler.PostObject)))
 
-	return mux
+	return handler.Authenticate(mux)
 }
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"triple-s/internal/structure"
+)
+
+const (
+	BackendFile   = "file"
+	BackendMemory = "memory"
+)
+
+var (
+	ErrBucketNotFound   = errors.New("bucket not found")
+	ErrBucketExists     = errors.New("bucket already exists")
+	ErrBucketNotEmpty   = errors.New("bucket is not empty")
+	ErrObjectNotFound   = errors.New("object not found")
+	ErrObjectExists     = errors.New("object already exists")
+	ErrObjectChanged    = errors.New("object has changed")
+	ErrNoSuchVersion    = errors.New("object version does not exist")
+	ErrNoSuchUpload     = errors.New("multipart upload does not exist")
+	ErrInvalidPart      = errors.New("one or more of the specified parts could not be found")
+	ErrInvalidPartOrder = errors.New("the list of parts was not in ascending order")
+	ErrEntityTooSmall   = errors.New("proposed upload is smaller than the minimum allowed size")
+)
+
+type Backend interface {
+	CreateBucket(bucket structure.Bucket) error
+	BucketExists(bucketName string) (bool, error)
+	GetBucket(bucketName string) (*structure.Bucket, error)
+	// UpdateBucket applies update to the stored attributes of an existing
+	// bucket under the same lock that writes them back, so concurrent
+	// updates of different attributes do not undo each other. Nothing is
+	// stored if update returns an error, which is passed on.
+	UpdateBucket(bucketName string, update func(*structure.Bucket) error) error
+	ListBuckets() ([]structure.Bucket, error)
+	// DeleteBucket removes a bucket that holds no objects or versions. It
+	// returns ErrBucketNotEmpty otherwise, checked under the same lock
+	// that keeps objects from being stored meanwhile.
+	DeleteBucket(bucketName string) error
+	IsBucketEmpty(bucketName string) (bool, error)
+
+	// StoreObject writes data as the current version of objectKey. With
+	// createOnly set it fails with ErrObjectExists instead if the key
+	// already has a current version, as for If-None-Match: *.
+	StoreObject(bucketName, objectKey string, data io.Reader, object *structure.Object, createOnly bool) error
+	ObjectExists(bucketName, objectKey string) (bool, error)
+	// GetObject returns the metadata of the current version of objectKey
+	// along with its data, both read under one lock so that they agree
+	// even if the key is overwritten at the same time.
+	GetObject(bucketName, objectKey string) (*structure.Object, io.ReadSeekCloser, error)
+	GetObjectMetadata(bucketName, objectKey string) (*structure.Object, error)
+	// UpdateObject applies update to the stored metadata of the current
+	// version of objectKey under the bucket lock, so that it cannot mix
+	// with the record of a concurrent overwrite. The attributes that
+	// describe the data, such as the size, ETag and version ID, are kept.
+	UpdateObject(bucketName, objectKey string, update func(*structure.Object) error) error
+	ListObjects(bucketName string) ([]structure.Object, error)
+	// WalkObjects calls fn with the current version of every object whose
+	// key starts with prefix and sorts after startAfter, in key order,
+	// until fn returns false. fn runs under the bucket lock and must not
+	// call the backend.
+	WalkObjects(bucketName, prefix, startAfter string, fn func(structure.Object) bool) error
+	DeleteObject(bucketName, objectKey string) error
+	DeleteObjects(bucketName string, objectKeys []string) (map[string]error, error)
+	// CopyObject stores a copy of the source object as dstKey. When
+	// checked is set, the copy fails with ErrObjectChanged unless the
+	// source is still the version described by checked, so that
+	// conditions evaluated against it hold for the data copied.
+	CopyObject(srcBucket, srcKey, srcVersionID string, checked *structure.Object, dstBucket, dstKey string, object *structure.Object) error
+
+	PutDeleteMarker(bucketName, objectKey, versionID string) error
+
+	// ExpireObject removes the current version of observed.ObjectKey like
+	// DeleteObject, or hides it behind a delete marker like
+	// PutDeleteMarker when versionID is set, provided it is still the
+	// version observed: the one with the same ETag, version ID and
+	// modification time. It returns ErrObjectChanged otherwise, checked
+	// under the bucket lock.
+	ExpireObject(bucketName string, observed structure.Object, versionID string) error
+	// GetObjectVersion is GetObject for versionID. A delete marker is
+	// returned with no data.
+	GetObjectVersion(bucketName, objectKey, versionID string) (*structure.Object, io.ReadSeekCloser, error)
+	GetObjectVersionMetadata(bucketName, objectKey, versionID string) (*structure.Object, error)
+	DeleteObjectVersion(bucketName, objectKey, versionID string) (*structure.Object, error)
+	ListObjectVersions(bucketName string) ([]structure.Object, error)
+
+	CreateMultipartUpload(bucketName string, object structure.Object) (string, error)
+	UploadPart(bucketName, objectKey, uploadID string, partNumber int, data io.Reader) (*structure.Part, error)
+	ListParts(bucketName, objectKey, uploadID string) ([]structure.Part, error)
+	CompleteMultipartUpload(bucketName, objectKey, uploadID string, parts []structure.CompletedPart, versionID string) (*structure.Object, error)
+	AbortMultipartUpload(bucketName, objectKey, uploadID string) error
+	ListMultipartUploads(bucketName string) ([]structure.Upload, error)
+}
+
+func NewBackend(kind, dataDir string) (Backend, error) {
+	switch kind {
+	case BackendFile:
+		backend, err := NewFileBackend(dataDir)
+		if err != nil {
+			return nil, err
+		}
+		return backend, nil
+	case BackendMemory:
+		return NewMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", kind)
+	}
+}
@@ -0,0 +1,64 @@
+package storage
+
+import "triple-s/internal/kv"
+
+// DeleteObjects removes the current objects named by objectKeys in a
+// single metadata transaction, however many keys are given. Keys that do
+// not exist are ignored. Objects whose data cannot be removed are kept and
+// their errors returned by key.
+func (b *FileBackend) DeleteObjects(bucketName string, objectKeys []string) (map[string]error, error) {
+	unlock, err := b.lock(true, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return b.deleteObjects(bucketName, objectKeys)
+}
+
+// deleteObjects removes the objects among objectKeys that exist. The
+// caller must hold the bucket lock.
+func (b *FileBackend) deleteObjects(bucketName string, objectKeys []string) (map[string]error, error) {
+	entry := &intent{
+		Op:     opDeleteObjects,
+		Bucket: bucketName,
+		failed: make(map[string]error),
+	}
+
+	err := b.db.View(func(tx *kv.Tx) error {
+		for _, objectKey := range objectKeys {
+			object, err := getObject(tx, bucketName, objectKey)
+			if err != nil {
+				return err
+			}
+			if object != nil {
+				entry.Keys = append(entry.Keys, objectKey)
+			}
+		}
+		return nil
+	})
+	if err != nil || len(entry.Keys) == 0 {
+		return entry.failed, err
+	}
+
+	err = b.execute(entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry.failed, nil
+}
+
+func (m *MemoryBackend) DeleteObjects(bucketName string, objectKeys []string) (map[string]error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+
+	for _, objectKey := range objectKeys {
+		delete(bucket.objects, objectKey)
+	}
+	return map[string]error{}, nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+
+	"triple-s/internal/kv"
+	"triple-s/internal/structure"
+)
+
+// CopyObject stores a copy of the source object, or of srcVersionID when it
+// is set, as dstKey in dstBucket. The size and ETag of object are taken from
+// the source; everything else about the copy is described by object. When
+// checked is set, the source must still be that version: it is compared
+// under the source bucket lock and ErrObjectChanged returned if it differs.
+//
+// The source data is hard-linked into the destination bucket when the file
+// system allows it, so even large copies are instant. Object files are only
+// ever replaced by rename, never rewritten in place, so the copy and the
+// source cannot affect each other afterwards.
+func (b *FileBackend) CopyObject(srcBucket, srcKey, srcVersionID string, checked *structure.Object, dstBucket, dstKey string, object *structure.Object) error {
+	tmpPath, err := b.linkSource(srcBucket, srcKey, srcVersionID, checked, dstBucket, object)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	unlock, err := b.lock(true, dstBucket)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return b.commitObject(dstBucket, dstKey, tmpPath, object, false)
+}
+
+// linkSource makes a temporary file in dstBucket holding the data of the
+// source object. Only the source bucket is locked while it does so; the
+// destination bucket is locked separately to commit the copy, so that
+// copies in opposite directions cannot deadlock.
+func (b *FileBackend) linkSource(srcBucket, srcKey, srcVersionID string, checked *structure.Object, dstBucket string, object *structure.Object) (string, error) {
+	unlock, err := b.lock(false, srcBucket)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	var source *structure.Object
+	var srcPath string
+	err = b.db.View(func(tx *kv.Tx) error {
+		source, srcPath, err = b.findSource(tx, srcBucket, srcKey, srcVersionID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if checked != nil && !sameObject(*source, *checked) {
+		return "", ErrObjectChanged
+	}
+
+	name, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+
+	tmpPath := filepath.Join(b.dataDir, dstBucket, ".copy-"+name)
+	err = os.Link(srcPath, tmpPath)
+	if err != nil {
+		// Hard links fail across devices and on some file systems; fall
+		// back to copying the data.
+		tmpPath, err = copyFile(srcPath, filepath.Join(b.dataDir, dstBucket), object)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	object.Size = source.Size
+	object.ETag = source.ETag
+	return tmpPath, nil
+}
+
+// findSource looks up the current object, or a specific version when
+// versionID is set, and returns it with the path of its data. The caller
+// must hold the bucket lock.
+func (b *FileBackend) findSource(tx *kv.Tx, bucketName, objectKey, versionID string) (*structure.Object, string, error) {
+	if versionID != "" {
+		version, dataPath, err := b.findVersion(tx, bucketName, objectKey, versionID)
+		if err != nil {
+			return nil, "", err
+		}
+		if version.DeleteMarker {
+			return nil, "", ErrNoSuchVersion
+		}
+		return version, dataPath, nil
+	}
+
+	object, err := getObject(tx, bucketName, objectKey)
+	if err != nil {
+		return nil, "", err
+	}
+	if object == nil {
+		return nil, "", ErrObjectNotFound
+	}
+	return object, b.keyPath(bucketName, objectKey), nil
+}
+
+func copyFile(srcPath, dir string, object *structure.Object) (string, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	return writeTempFile(dir, src, object)
+}
+
+func (m *MemoryBackend) CopyObject(srcBucket, srcKey, srcVersionID string, checked *structure.Object, dstBucket, dstKey string, object *structure.Object) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	source, ok := m.buckets[srcBucket]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	destination, ok := m.buckets[dstBucket]
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	var src *memoryObject
+	if srcVersionID != "" {
+		src, ok = source.findVersion(srcKey, srcVersionID)
+		if !ok || src.info.DeleteMarker {
+			return ErrNoSuchVersion
+		}
+	} else {
+		src, ok = source.objects[srcKey]
+		if !ok {
+			return ErrObjectNotFound
+		}
+	}
+	if checked != nil && !sameObject(src.info, *checked) {
+		return ErrObjectChanged
+	}
+
+	object.Size = src.info.Size
+	object.ETag = src.info.ETag
+
+	// Stored data is never modified, so the copy can share it.
+	destination.put(&memoryObject{
+		info: *object,
+		data: src.data,
+	})
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+func flock(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func funlock(file *os.File) error {
+	return nil
+}
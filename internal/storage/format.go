@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// formatFile records the version of the on-disk layout of a data directory.
+// Directories written before it existed, with buckets.csv and per-bucket
+// objects.csv files, count as version 0.
+const formatFile = "format"
+
+// FormatVersion is the layout written by this build: metadata in
+// metadata.db and the data of every key in a directory of its own.
+const FormatVersion = 1
+
+var (
+	ErrLegacyFormat      = errors.New("data directory uses the layout of an older version; convert it with triple-s migrate")
+	ErrUnsupportedFormat = errors.New("unsupported data directory format")
+)
+
+// readFormat returns the format version recorded in dataDir. It returns an
+// error satisfying os.IsNotExist if none is.
+func readFormat(dataDir string) (int, error) {
+	content, err := os.ReadFile(filepath.Join(dataDir, formatFile))
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("%w: unreadable %s file", ErrUnsupportedFormat, formatFile)
+	}
+	return version, nil
+}
+
+func writeFormat(dataDir string) error {
+	return writeFileAtomic(filepath.Join(dataDir, formatFile), func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, FormatVersion)
+		return err
+	})
+}
+
+// checkFormat makes sure dataDir can be opened by this build. A new
+// directory is stamped with the current format. The caller must hold the
+// data directory lock.
+func checkFormat(dataDir string) error {
+	version, err := readFormat(dataDir)
+	if os.IsNotExist(err) {
+		if exists(filepath.Join(dataDir, legacyBucketsCSV)) && !exists(filepath.Join(dataDir, metadataDB)) {
+			return ErrLegacyFormat
+		}
+		return writeFormat(dataDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	if version != FormatVersion {
+		return fmt.Errorf("%w: data directory has format version %d, this build supports %d", ErrUnsupportedFormat, version, FormatVersion)
+	}
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
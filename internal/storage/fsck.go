@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"triple-s/internal/kv"
+	"triple-s/internal/structure"
+)
+
+// FsckReport describes the problems found by Fsck.
+type FsckReport struct {
+	Buckets  int
+	Objects  int
+	Versions int
+
+	// Problems lists, one line each, the places where the metadata and the
+	// data directory disagree and how that is resolved.
+	Problems []string
+
+	// Repaired is set when the resolutions in Problems were applied.
+	Repaired bool
+}
+
+func (r *FsckReport) reconcile(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// errDryRun rolls back the repairs of a check that only reports them.
+var errDryRun = errors.New("dry run")
+
+// Fsck cross-checks every bucket, object and version recorded in the
+// metadata database of dataDir against the data directory, and hashes
+// every file against its ETag. Records that cannot be decoded and records
+// whose data is missing are dropped, sizes and ETags are taken from the
+// files, and bucket directories and files with no record are added. These
+// repairs are only made if repair is set.
+//
+// Interrupted operations in the write-ahead log are recovered first when
+// repairing and only reported otherwise. Files in the version history that
+// no record refers to are removed, since the key they belong to cannot be
+// told from their path.
+func Fsck(dataDir string, repair bool) (*FsckReport, error) {
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if !exists(filepath.Join(dataDir, metadataDB)) {
+		if exists(filepath.Join(dataDir, legacyBucketsCSV)) {
+			return nil, ErrLegacyFormat
+		}
+		return nil, fmt.Errorf("%s has no %s to check", dataDir, metadataDB)
+	}
+	err = checkFormat(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := kv.Open(filepath.Join(dataDir, metadataDB))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	b := &FileBackend{dataDir: dataDir, db: db}
+	if repair {
+		err = b.recover()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &FsckReport{Repaired: repair}
+	err = db.Update(func(tx *kv.Tx) error {
+		err := b.fsck(tx, true, report)
+		if err == nil && !repair {
+			return errDryRun
+		}
+		return err
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	return report, nil
+}
+
+// fsck reconciles the metadata with the data directory as described on
+// Fsck. Files are only hashed when verify is set.
+func (b *FileBackend) fsck(tx *kv.Tx, verify bool, report *FsckReport) error {
+	pending := 0
+	tx.Scan(walPrefix, func(key string, value []byte) bool {
+		pending++
+		return true
+	})
+	if pending > 0 {
+		report.reconcile("write-ahead log: %d interrupted operations, recovered on repair or when the server starts", pending)
+	}
+
+	var buckets []structure.Bucket
+	var corrupt []string
+	tx.Scan(bucketPrefix, func(key string, value []byte) bool {
+		var bucket structure.Bucket
+		if json.Unmarshal(value, &bucket) != nil || bucketKey(bucket.Name) != key {
+			corrupt = append(corrupt, key)
+			return true
+		}
+		buckets = append(buckets, bucket)
+		return true
+	})
+	for _, key := range corrupt {
+		report.reconcile("record %q: corrupt, dropped", key)
+		err := tx.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	recorded := make(map[string]bool)
+	for _, bucket := range buckets {
+		recorded[bucket.Name] = true
+	}
+	kept, err := reconcileBuckets(b.dataDir, buckets, report)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]bool)
+	for _, bucket := range kept {
+		known[bucket.Name] = true
+		if !recorded[bucket.Name] {
+			err = putBucket(tx, bucket)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Drop the objects and versions of buckets that are gone, whether
+	// their record was just dropped or never existed.
+	gone := make(map[string]bool)
+	for _, bucket := range buckets {
+		if !known[bucket.Name] {
+			gone[bucket.Name] = true
+		}
+	}
+	for _, prefix := range []string{objectPrefix, versionPrefix} {
+		tx.Scan(prefix, func(key string, value []byte) bool {
+			bucketName, _, _ := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+			if !known[bucketName] && !gone[bucketName] {
+				report.reconcile("bucket %s: records of objects in a bucket that does not exist, dropped", bucketName)
+				gone[bucketName] = true
+			}
+			return true
+		})
+	}
+	for bucketName := range gone {
+		err = deleteBucketRecords(tx, bucketName)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, bucket := range kept {
+		err = b.fsckBucket(tx, bucket.Name, verify, report)
+		if err != nil {
+			return err
+		}
+	}
+	report.Buckets = len(kept)
+	return nil
+}
+
+// fsckBucket checks the objects and versions of one bucket. When anything
+// is wrong the records of the bucket are rewritten from the reconciled
+// lists.
+func (b *FileBackend) fsckBucket(tx *kv.Tx, bucketName string, verify bool, report *FsckReport) error {
+	problems := len(report.Problems)
+	objects, keys := scanRecords(tx, objectsPrefix(bucketName), report, func(key string, object structure.Object) bool {
+		return key == objectsPrefix(bucketName)+object.ObjectKey
+	})
+	objects, err := b.reconcileObjects(bucketName, objects, verify, report)
+	if err != nil {
+		return err
+	}
+	if len(report.Problems) > problems {
+		err = deleteKeys(tx, keys)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			err = putObject(tx, bucketName, object)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	problems = len(report.Problems)
+	history, keys := scanRecords(tx, versionsPrefix(bucketName), report, func(key string, version structure.Object) bool {
+		return strings.HasPrefix(key, historyPrefix(bucketName, version.ObjectKey)) &&
+			len(key) == len(historyPrefix(bucketName, version.ObjectKey))+16
+	})
+	history, err = b.reconcileVersions(bucketName, history, verify, report)
+	if err != nil {
+		return err
+	}
+	if len(report.Problems) > problems {
+		err = deleteKeys(tx, keys)
+		if err != nil {
+			return err
+		}
+		for _, version := range history {
+			err = appendHistory(tx, bucketName, version)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = b.removeStrayVersions(bucketName, history, report)
+	if err != nil {
+		return err
+	}
+
+	report.Objects += len(objects)
+	report.Versions += len(history)
+	return nil
+}
+
+// scanRecords returns the object records under prefix that decode and are
+// stored under the key valid expects, reporting the others as corrupt,
+// along with the keys of all of them.
+func scanRecords(tx *kv.Tx, prefix string, report reconciler, valid func(key string, object structure.Object) bool) ([]structure.Object, []string) {
+	objects := []structure.Object{}
+	var keys []string
+	tx.Scan(prefix, func(key string, value []byte) bool {
+		keys = append(keys, key)
+
+		var object structure.Object
+		if json.Unmarshal(value, &object) != nil || !valid(key, object) {
+			report.reconcile("record %q: corrupt, dropped", key)
+			return true
+		}
+		objects = append(objects, object)
+		return true
+	})
+	return objects, keys
+}
+
+func deleteKeys(tx *kv.Tx, keys []string) error {
+	for _, key := range keys {
+		err := tx.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStrayVersions reports the files in the version history of a bucket
+// that no version record refers to, and removes them when repairing.
+func (b *FileBackend) removeStrayVersions(bucketName string, history []structure.Object, report *FsckReport) error {
+	referenced := make(map[string]bool)
+	for _, version := range history {
+		if !version.DeleteMarker {
+			referenced[b.versionPath(bucketName, version.ObjectKey, version.VersionID)] = true
+		}
+	}
+
+	root := filepath.Join(b.dataDir, bucketName, versionsDir)
+	var stray []string
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if path == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || referenced[path] {
+			return nil
+		}
+
+		relative, err := filepath.Rel(b.dataDir, path)
+		if err != nil {
+			return err
+		}
+		report.reconcile("version file %s: no record refers to it, removed", filepath.ToSlash(relative))
+		stray = append(stray, path)
+		return nil
+	})
+	if err != nil || !report.Repaired {
+		return err
+	}
+
+	bucketDir := filepath.Join(b.dataDir, bucketName)
+	for _, path := range stray {
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		removeEmptyParents(bucketDir, filepath.Dir(path))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Every object key has a directory of its own under the bucket directory,
+// derived from the key one slash-separated segment at a time, and its data
+// is stored in that directory as dataFile. A key can therefore have data of
+// its own and keys below it at the same time, as "a" and "a/b" do.
+//
+// Names starting with a dot belong to triple-s (dataFile, deletedFile,
+// .multipart, .versions and the temporary files of writes in progress), so
+// a segment starting with a dot has it escaped as %2E, every percent sign
+// is escaped as %25, and an empty segment is stored as a lone percent sign.
+// A segment that escapes to more than maxNameLength bytes is split over
+// nested directories, every one after the first prefixed with continuation.
+// This maps every key to its own path inside the bucket directory that no
+// other key and no internal file uses, and can be reversed to find the key
+// a file belongs to.
+const (
+	dataFile      = ".data"
+	deletedFile   = ".deleted"
+	continuation  = "%+"
+	maxNameLength = 255
+)
+
+// keyPath returns the path of the data of objectKey.
+func (b *FileBackend) keyPath(bucketName, objectKey string) string {
+	return filepath.Join(b.dataDir, bucketName, encodeKey(objectKey))
+}
+
+// encodeKey maps objectKey to the path of its data relative to the bucket
+// directory.
+func encodeKey(objectKey string) string {
+	var names []string
+	for _, segment := range strings.Split(objectKey, "/") {
+		names = append(names, splitSegment(encodeSegment(segment))...)
+	}
+	names = append(names, dataFile)
+	return strings.Join(names, string(filepath.Separator))
+}
+
+func encodeSegment(segment string) string {
+	if segment == "" {
+		return "%"
+	}
+	segment = strings.ReplaceAll(segment, "%", "%25")
+	if segment[0] == '.' {
+		segment = "%2E" + segment[1:]
+	}
+	return segment
+}
+
+// splitSegment cuts an escaped segment into names of at most maxNameLength
+// bytes. Cuts are made outside escapes and, in valid UTF-8, outside
+// multi-byte characters.
+func splitSegment(encoded string) []string {
+	var names []string
+	prefix := ""
+	for len(prefix)+len(encoded) > maxNameLength {
+		cut := maxNameLength - len(prefix)
+		for i := 1; i < utf8.UTFMax && !utf8.RuneStart(encoded[cut]); i++ {
+			cut--
+		}
+		if encoded[cut-1] == '%' {
+			cut--
+		} else if encoded[cut-2] == '%' {
+			cut -= 2
+		}
+		names = append(names, prefix+encoded[:cut])
+		encoded = encoded[cut:]
+		prefix = continuation
+	}
+	return append(names, prefix+encoded)
+}
+
+func decodeSegment(encoded string) string {
+	if encoded == "%" {
+		return ""
+	}
+	rest, dot := strings.CutPrefix(encoded, "%2E")
+	segment := strings.ReplaceAll(rest, "%25", "%")
+	if dot {
+		segment = "." + segment
+	}
+	return segment
+}
+
+// decodeKey returns the key whose data is stored at relative, a path inside
+// the bucket directory. It reports false for a path encodeKey does not
+// produce, which includes every internal file.
+func decodeKey(relative string) (string, bool) {
+	relative = filepath.ToSlash(relative)
+	dir, ok := strings.CutSuffix(relative, "/"+dataFile)
+	if !ok {
+		return "", false
+	}
+
+	var segments []string
+	for _, name := range strings.Split(dir, "/") {
+		if piece, ok := strings.CutPrefix(name, continuation); ok && len(segments) > 0 {
+			segments[len(segments)-1] += piece
+			continue
+		}
+		segments = append(segments, name)
+	}
+	for i, encoded := range segments {
+		segments[i] = decodeSegment(encoded)
+	}
+
+	objectKey := strings.Join(segments, "/")
+	if filepath.ToSlash(encodeKey(objectKey)) != relative {
+		return "", false
+	}
+	return objectKey, true
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzEncodeKey(f *testing.F) {
+	for _, seed := range []string{
+		"a", "a/b", "dir/", "/lead", "a//b", ".", "..", ".hidden/.data", "%", "100%/%2E",
+		".versions/x", "%+cont", strings.Repeat("k", 300), strings.Repeat("%", 300),
+		strings.Repeat("é", 200), strings.Repeat("ab/", 100),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, objectKey string) {
+		encoded := encodeKey(objectKey)
+
+		decoded, ok := decodeKey(encoded)
+		if !ok || decoded != objectKey {
+			t.Fatalf("decodeKey(%q) = %q, %v; want %q", encoded, decoded, ok, objectKey)
+		}
+		if !filepath.IsLocal(encoded) {
+			t.Fatalf("encodeKey(%q) = %q is not local", objectKey, encoded)
+		}
+
+		names := strings.Split(filepath.ToSlash(encoded), "/")
+		for i, name := range names {
+			if i == len(names)-1 {
+				if name != dataFile {
+					t.Fatalf("encodeKey(%q) = %q does not end in %s", objectKey, encoded, dataFile)
+				}
+				continue
+			}
+			if strings.HasPrefix(name, ".") {
+				t.Fatalf("encodeKey(%q) = %q has a segment starting with a dot", objectKey, encoded)
+			}
+			if len(name) > maxNameLength {
+				t.Fatalf("encodeKey(%q) = %q has a name longer than %d bytes", objectKey, encoded, maxNameLength)
+			}
+		}
+	})
+}
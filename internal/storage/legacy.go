@@ -0,0 +1,638 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"triple-s/internal/kv"
+	"triple-s/internal/structure"
+)
+
+// Metadata files written by versions before the metadata database.
+const (
+	legacyBucketsCSV  = "buckets.csv"
+	legacyObjectsCSV  = "objects.csv"
+	legacyVersionsCSV = "versions.csv"
+)
+
+// MigrationReport describes a conversion done by Migrate.
+type MigrationReport struct {
+	Buckets  int
+	Objects  int
+	Versions int
+
+	// Reconciled lists, one line each, the places where the CSV files and
+	// the data directory disagreed and how that was resolved.
+	Reconciled []string
+
+	// UpToDate is set when the directory needed no conversion.
+	UpToDate bool
+}
+
+func (r *MigrationReport) reconcile(format string, args ...any) {
+	r.Reconciled = append(r.Reconciled, fmt.Sprintf(format, args...))
+}
+
+// Migrate converts a data directory written by older versions, with
+// buckets.csv and per-bucket objects.csv and versions.csv files, to the
+// current format. The CSV files are replaced by a metadata database and the
+// data of every object is moved from the path of its key to the path
+// keyPath gives it. The metadata is reconciled with what is actually on
+// disk: records whose data is gone are dropped, sizes and ETags are taken
+// from the files when they disagree, and bucket directories and files with
+// no record are added.
+//
+// The new metadata database is built under a temporary name and renamed
+// into place once complete, and files are only moved to paths that are
+// free, so an interrupted migration can simply be run again. The CSV files
+// are removed only after the format version has been recorded. No server
+// may be running on the directory.
+func Migrate(dataDir string) (*MigrationReport, error) {
+	unlock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	report := &MigrationReport{}
+	bucketsPath := filepath.Join(dataDir, legacyBucketsCSV)
+	if exists(filepath.Join(dataDir, metadataDB)) {
+		version, err := readFormat(dataDir)
+		if os.IsNotExist(err) && exists(bucketsPath) {
+			// A migration was interrupted between renaming the metadata
+			// database into place and recording the format.
+			return report, finishMigration(dataDir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if version != FormatVersion {
+			return nil, fmt.Errorf("%w: data directory has format version %d, this build supports %d", ErrUnsupportedFormat, version, FormatVersion)
+		}
+		report.UpToDate = true
+		return report, nil
+	}
+
+	buckets, err := readLegacyBuckets(bucketsPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s has no %s to migrate", dataDir, legacyBucketsCSV)
+	}
+	if err != nil {
+		return nil, err
+	}
+	buckets, err = reconcileBuckets(dataDir, buckets, report)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpPath := filepath.Join(dataDir, metadataDB+".migrate")
+	err = os.Remove(tmpPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+
+	db, err := kv.Open(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &FileBackend{dataDir: dataDir}
+	err = db.Update(func(tx *kv.Tx) error {
+		for _, bucket := range buckets {
+			err := putBucket(tx, bucket)
+			if err != nil {
+				return err
+			}
+
+			objects, err := readObjectsFile(filepath.Join(dataDir, bucket.Name, legacyObjectsCSV))
+			if err != nil {
+				return err
+			}
+			err = b.relocateObjects(bucket.Name, objects)
+			if err != nil {
+				return err
+			}
+			objects, err = b.reconcileObjects(bucket.Name, objects, false, report)
+			if err != nil {
+				return err
+			}
+			for _, object := range objects {
+				err = putObject(tx, bucket.Name, object)
+				if err != nil {
+					return err
+				}
+			}
+
+			history, err := readObjectsFile(filepath.Join(dataDir, bucket.Name, legacyVersionsCSV))
+			if err != nil {
+				return err
+			}
+			history, err = b.reconcileVersions(bucket.Name, history, false, report)
+			if err != nil {
+				return err
+			}
+			for _, version := range history {
+				err = appendHistory(tx, bucket.Name, version)
+				if err != nil {
+					return err
+				}
+			}
+
+			report.Buckets++
+			report.Objects += len(objects)
+			report.Versions += len(history)
+		}
+		return nil
+	})
+	closeErr := db.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	err = os.Rename(tmpPath, filepath.Join(dataDir, metadataDB))
+	if err != nil {
+		return nil, err
+	}
+	return report, finishMigration(dataDir)
+}
+
+// finishMigration records the format of a data directory whose metadata
+// database is complete and removes the CSV files it replaced.
+func finishMigration(dataDir string) error {
+	err := writeFormat(dataDir)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			os.Remove(filepath.Join(dataDir, entry.Name(), legacyObjectsCSV))
+			os.Remove(filepath.Join(dataDir, entry.Name(), legacyVersionsCSV))
+		}
+	}
+	os.Remove(filepath.Join(dataDir, legacyBucketsCSV))
+	return nil
+}
+
+// relocateObjects moves the data of objects, and of the files in the bucket
+// directory that have no record, from the path of their key to keyPath.
+// Keys are moved longest path first and never onto an existing file, since
+// the old path of one key can be the new path of another. A key whose old
+// path leaves the bucket directory or belongs to another recorded key, as
+// that of a//b belongs to a/b, is not moved; reconciling then drops it as
+// having no data.
+func (b *FileBackend) relocateObjects(bucketName string, objects []structure.Object) error {
+	bucketDir := filepath.Join(b.dataDir, bucketName)
+	recorded := make(map[string]bool)
+	for _, object := range objects {
+		recorded[object.ObjectKey] = true
+	}
+
+	keys := make([]string, 0, len(objects))
+	for _, object := range objects {
+		keys = append(keys, object.ObjectKey)
+	}
+	found, err := b.legacyKeys(bucketName)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, objectKey := range found {
+		if !recorded[objectKey] && !seen[objectKey] {
+			seen[objectKey] = true
+			keys = append(keys, objectKey)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return len(encodeKey(keys[i])) > len(encodeKey(keys[j]))
+	})
+
+	for _, objectKey := range keys {
+		oldPath := filepath.Join(bucketDir, objectKey)
+		newPath := b.keyPath(bucketName, objectKey)
+		relative, err := filepath.Rel(bucketDir, oldPath)
+		if err != nil || !filepath.IsLocal(relative) {
+			continue
+		}
+		if other := filepath.ToSlash(relative); other != objectKey && recorded[other] {
+			continue
+		}
+
+		// The data of a key moves below its old path, into the directory
+		// of the key, so the file is moved aside first.
+		from := oldPath
+		if strings.HasPrefix(newPath, oldPath+string(filepath.Separator)) {
+			from = filepath.Join(filepath.Dir(oldPath), ".relocate-"+filepath.Base(oldPath))
+			info, err := os.Stat(oldPath)
+			if err == nil && info.Mode().IsRegular() && !exists(from) {
+				err = os.Rename(oldPath, from)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		info, err := os.Stat(from)
+		if err != nil || !info.Mode().IsRegular() || exists(newPath) {
+			continue
+		}
+		err = os.MkdirAll(filepath.Dir(newPath), 0o755)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(from, newPath)
+		if err != nil {
+			return err
+		}
+		removeEmptyParents(bucketDir, filepath.Dir(from))
+	}
+	return nil
+}
+
+// legacyKeys returns the keys of the files a bucket directory written by an
+// older version holds, counting the files an interrupted migration moved
+// aside.
+func (b *FileBackend) legacyKeys(bucketName string) ([]string, error) {
+	bucketDir := filepath.Join(b.dataDir, bucketName)
+
+	var keys []string
+	err := filepath.WalkDir(bucketDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || path == bucketDir {
+			return err
+		}
+		if entry.IsDir() {
+			if strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		name := strings.TrimPrefix(entry.Name(), ".relocate-")
+		if strings.HasPrefix(name, ".") || !entry.Type().IsRegular() {
+			return nil
+		}
+		relative, err := filepath.Rel(bucketDir, filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			return err
+		}
+		if isLegacyMetadata(relative) {
+			return nil
+		}
+
+		keys = append(keys, filepath.ToSlash(relative))
+		return nil
+	})
+	return keys, err
+}
+
+// isLegacyMetadata reports whether a file found in a bucket directory is one
+// of the CSV files older versions kept metadata in.
+func isLegacyMetadata(relative string) bool {
+	return relative == legacyObjectsCSV || relative == legacyVersionsCSV
+}
+
+// reconciler collects the changes made while bringing metadata in line
+// with the data directory.
+type reconciler interface {
+	reconcile(format string, args ...any)
+}
+
+// reconcileBuckets drops the buckets whose directory is missing and adds
+// the directories that have no bucket record.
+func reconcileBuckets(dataDir string, buckets []structure.Bucket, report reconciler) ([]structure.Bucket, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	directories := make(map[string]os.DirEntry)
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			directories[entry.Name()] = entry
+		}
+	}
+
+	kept := []structure.Bucket{}
+	for _, bucket := range buckets {
+		if directories[bucket.Name] == nil {
+			report.reconcile("bucket %s: directory missing, record dropped", bucket.Name)
+			continue
+		}
+		delete(directories, bucket.Name)
+		kept = append(kept, bucket)
+	}
+
+	for _, entry := range entries {
+		if directories[entry.Name()] == nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		kept = append(kept, structure.Bucket{
+			Name:         entry.Name(),
+			CreationTime: info.ModTime(),
+			LastModified: info.ModTime(),
+			Status:       "active",
+		})
+		report.reconcile("bucket %s: directory without a record, added", entry.Name())
+	}
+
+	return kept, nil
+}
+
+// reconcileObjects brings the object records of a bucket in line with the
+// files in the bucket directory. With verify set the content of every file
+// is hashed and checked against its ETag.
+func (b *FileBackend) reconcileObjects(bucketName string, objects []structure.Object, verify bool, report reconciler) ([]structure.Object, error) {
+	bucketDir := filepath.Join(b.dataDir, bucketName)
+
+	kept := []structure.Object{}
+	recorded := make(map[string]bool)
+	for _, object := range objects {
+		if recorded[object.ObjectKey] {
+			report.reconcile("object %s/%s: duplicate record dropped", bucketName, object.ObjectKey)
+			continue
+		}
+
+		subject := fmt.Sprintf("object %s/%s", bucketName, object.ObjectKey)
+		found, err := reconcileData(b.keyPath(bucketName, object.ObjectKey), subject, &object, verify, report)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			report.reconcile("%s: data missing, record dropped", subject)
+			continue
+		}
+
+		recorded[object.ObjectKey] = true
+		kept = append(kept, object)
+	}
+
+	err := filepath.WalkDir(bucketDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || path == bucketDir {
+			return err
+		}
+
+		relative, err := filepath.Rel(bucketDir, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		objectKey, ok := decodeKey(relative)
+		if !ok || recorded[objectKey] || !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		etag, err := fileETag(path)
+		if err != nil {
+			return err
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(objectKey))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		kept = append(kept, structure.Object{
+			ObjectKey:    objectKey,
+			Size:         info.Size(),
+			ContentType:  contentType,
+			LastModified: info.ModTime(),
+			ETag:         etag,
+		})
+		report.reconcile("object %s/%s: file without a record, added", bucketName, objectKey)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return kept, nil
+}
+
+// reconcileVersions drops the noncurrent versions of a bucket whose data is
+// missing and corrects the size and ETag of the others like
+// reconcileObjects. Delete markers have no data.
+func (b *FileBackend) reconcileVersions(bucketName string, history []structure.Object, verify bool, report reconciler) ([]structure.Object, error) {
+	kept := []structure.Object{}
+	for _, version := range history {
+		if !version.DeleteMarker {
+			subject := fmt.Sprintf("version %s of %s/%s", reportedVersion(version.VersionID), bucketName, version.ObjectKey)
+			found, err := reconcileData(b.versionPath(bucketName, version.ObjectKey, version.VersionID), subject, &version, verify, report)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				report.reconcile("%s: data missing, record dropped", subject)
+				continue
+			}
+		}
+		kept = append(kept, version)
+	}
+	return kept, nil
+}
+
+// reconcileData compares the recorded size and ETag of object with the file
+// at path and takes them from the file when they disagree. It reports
+// whether the file exists. The file is only hashed when the sizes differ,
+// unless verify is set; the ETag of a multipart upload is not the MD5 of
+// the content, so it is only ever checked through the size.
+func reconcileData(path, subject string, object *structure.Object, verify bool, report reconciler) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false, nil
+	}
+
+	multipart := strings.Contains(object.ETag, "-")
+	if info.Size() == object.Size && (!verify || multipart) {
+		return true, nil
+	}
+
+	etag, err := fileETag(path)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != object.Size {
+		report.reconcile("%s: recorded size %d, file has %d; size and ETag updated", subject, object.Size, info.Size())
+		object.Size = info.Size()
+		object.ETag = etag
+	} else if etag != object.ETag {
+		report.reconcile("%s: recorded ETag %s, content hashes to %s; ETag updated", subject, object.ETag, etag)
+		object.ETag = etag
+	}
+	return true, nil
+}
+
+func fileETag(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func readLegacyBuckets(csvPath string) ([]structure.Bucket, error) {
+	file, err := os.Open(csvPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := []structure.Bucket{}
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && record[0] == "Name" {
+			continue
+		}
+		if len(record) < 4 {
+			log.Printf("Not enough fields in line %d: expected 4, got %d", i+1, len(record))
+			continue
+		}
+
+		creationTime, err := time.Parse(time.RFC3339, record[1])
+		if err != nil {
+			log.Printf("Failed to parse CreationTime in line %d: %v", i+1, err)
+			continue
+		}
+		modifiedTime, err := time.Parse(time.RFC3339, record[2])
+		if err != nil {
+			log.Printf("Failed to parse ModifiedTime in line %d: %v", i+1, err)
+			continue
+		}
+
+		bucket := structure.Bucket{
+			Name:         record[0],
+			CreationTime: creationTime,
+			LastModified: modifiedTime,
+			Status:       record[3],
+		}
+		if len(record) > 4 {
+			bucket.Owner = record[4]
+		}
+		if len(record) > 5 {
+			bucket.Policy = record[5]
+		}
+		if len(record) > 6 {
+			bucket.ACL = record[6]
+		}
+		if len(record) > 7 {
+			bucket.Versioning = record[7]
+		}
+		if len(record) > 8 {
+			bucket.Lifecycle = record[8]
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, nil
+}
+
+// readObjectsFile parses a file in the objects.csv format, which
+// versions.csv shares. A missing file holds no objects.
+func readObjectsFile(csvPath string) ([]structure.Object, error) {
+	file, err := os.Open(csvPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []structure.Object{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []structure.Object{}
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && record[0] == "ObjectKey" {
+			continue
+		}
+
+		if len(record) < 4 {
+			log.Printf("Not enough fields in line %d: expected 4, got %d", i+1, len(record))
+			continue
+		}
+
+		size, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			log.Printf("Failed to parse Size in line %d: %v", i+1, err)
+			continue
+		}
+		lastModified, err := time.Parse(time.RFC3339, record[3])
+		if err != nil {
+			log.Printf("Failed to parse LastModified in line %d: %v", i+1, err)
+			continue
+		}
+
+		object := structure.Object{
+			ObjectKey:    record[0],
+			Size:         size,
+			ContentType:  record[2],
+			LastModified: lastModified,
+		}
+		if len(record) > 4 {
+			object.ETag = record[4]
+		}
+		if len(record) > 5 {
+			object.ACL = record[5]
+		}
+		if len(record) > 6 {
+			object.VersionID = record[6]
+		}
+		if len(record) > 7 {
+			object.DeleteMarker = record[7] == "true"
+		}
+		if len(record) > 8 {
+			object.Tagging = record[8]
+		}
+		object.ObjectHeaders = parseHeaderColumns(record, 9)
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
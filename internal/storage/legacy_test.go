@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMigrateCSV writes a data directory the way versions before the
+// metadata database did and checks that Migrate converts it, reconciling
+// the CSV files with the files on disk.
+func TestMigrateCSV(t *testing.T) {
+	dataDir := t.TempDir()
+	bucketDir := filepath.Join(dataDir, "old-bucket")
+
+	keys := []string{"plain.txt", ".config/100%", "a/b/c", "dir/file"}
+	objectsCSV := "ObjectKey,Size,ContentType,LastModified,ETag\n"
+	for _, key := range append(keys, "gone", "a//b/c") {
+		content := "data of " + key
+		hash := md5.Sum([]byte(content))
+		objectsCSV += fmt.Sprintf("%s,%d,text/plain,2024-01-01T00:00:00Z,%s\n", key, len(content), hex.EncodeToString(hash[:]))
+	}
+	for _, key := range keys {
+		writeTestFile(t, filepath.Join(bucketDir, key), "data of "+key)
+	}
+	writeTestFile(t, filepath.Join(bucketDir, "extra", "file"), "data of extra/file")
+	writeTestFile(t, filepath.Join(bucketDir, legacyObjectsCSV), objectsCSV)
+	writeTestFile(t, filepath.Join(dataDir, "new-bucket", "x"), "data of x")
+	writeTestFile(t, filepath.Join(dataDir, legacyBucketsCSV),
+		"Name,CreationTime,LastModifiedTime,Status\nold-bucket,2024-01-01T00:00:00Z,2024-01-01T00:00:00Z,active\n")
+
+	_, err := NewFileBackend(dataDir)
+	if !errors.Is(err, ErrLegacyFormat) {
+		t.Fatalf("opened a CSV data directory: %v", err)
+	}
+
+	report, err := Migrate(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UpToDate || report.Buckets != 2 || report.Objects != len(keys)+2 {
+		t.Errorf("migrated %d buckets and %d objects (up to date: %v), want 2 and %d", report.Buckets, report.Objects, report.UpToDate, len(keys)+2)
+	}
+	if len(report.Reconciled) != 5 {
+		t.Errorf("reconciled %q, want the missing and shadowed records and the unrecorded bucket and files", report.Reconciled)
+	}
+	for _, path := range []string{legacyBucketsCSV, filepath.Join("old-bucket", legacyObjectsCSV)} {
+		if exists(filepath.Join(dataDir, path)) {
+			t.Errorf("%s left behind", path)
+		}
+	}
+
+	b, err := NewFileBackend(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.db.Close()
+	for _, key := range append(keys, "extra/file") {
+		checkObjectData(t, b, "old-bucket", key, "data of "+key)
+	}
+	checkObjectData(t, b, "new-bucket", "x", "data of x")
+	for _, key := range []string{"gone", "a//b/c"} {
+		_, _, err := b.GetObject("old-bucket", key)
+		if !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("%s: %v, want %v", key, err, ErrObjectNotFound)
+		}
+	}
+
+	report, err = Migrate(dataDir)
+	if err != nil || !report.UpToDate {
+		t.Errorf("second migration: up to date %v, error %v", report != nil && report.UpToDate, err)
+	}
+}
+
+// TestMigrateInterrupted checks that Migrate finishes a migration that
+// stopped after the metadata database was complete.
+func TestMigrateInterrupted(t *testing.T) {
+	dataDir := t.TempDir()
+	writeTestFile(t, filepath.Join(dataDir, "bucket", "key"), "data of key")
+	writeTestFile(t, filepath.Join(dataDir, legacyBucketsCSV), "")
+	_, err := Migrate(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Remove(filepath.Join(dataDir, formatFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dataDir, legacyBucketsCSV), "")
+	writeTestFile(t, filepath.Join(dataDir, "bucket", legacyObjectsCSV), "")
+
+	report, err := Migrate(dataDir)
+	if err != nil || report.UpToDate {
+		t.Fatalf("finishing the migration: up to date %v, error %v", report != nil && report.UpToDate, err)
+	}
+	content, err := os.ReadFile(filepath.Join(dataDir, formatFile))
+	if err != nil || strings.TrimSpace(string(content)) != fmt.Sprint(FormatVersion) {
+		t.Errorf("format file %q (%v), want %d", content, err, FormatVersion)
+	}
+	if exists(filepath.Join(dataDir, legacyBucketsCSV)) || exists(filepath.Join(dataDir, "bucket", legacyObjectsCSV)) {
+		t.Error("CSV files left behind")
+	}
+
+	b, err := NewFileBackend(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.db.Close()
+	checkObjectData(t, b, "bucket", "key", "data of key")
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
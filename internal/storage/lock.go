@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	lockFile = ".lock"
+
+	// catalogLock guards the bucket records. Bucket names are at least three
+	// characters long, so the empty name never collides with a bucket.
+	catalogLock = ""
+)
+
+type lockRegistry struct {
+	mu    sync.Mutex
+	locks map[string]*sync.RWMutex
+}
+
+func newLockRegistry() *lockRegistry {
+	return &lockRegistry{
+		locks: make(map[string]*sync.RWMutex),
+	}
+}
+
+func (r *lockRegistry) get(name string) *sync.RWMutex {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lock, ok := r.locks[name]
+	if !ok {
+		lock = &sync.RWMutex{}
+		r.locks[name] = lock
+	}
+	return lock
+}
+
+// lock takes the in-process locks for names, in order, and then a single
+// advisory lock on the data directory, so that several triple-s processes
+// sharing one directory cannot interleave metadata writes. Once the
+// directory is locked, transactions committed by other processes are read
+// into the metadata database. Callers that need both must pass catalogLock
+// before the bucket name.
+func (b *FileBackend) lock(exclusive bool, names ...string) (func(), error) {
+	mutexes := make([]*sync.RWMutex, 0, len(names))
+	for _, name := range names {
+		mu := b.locks.get(name)
+		if exclusive {
+			mu.Lock()
+		} else {
+			mu.RLock()
+		}
+		mutexes = append(mutexes, mu)
+	}
+
+	release := func() {
+		for i := len(mutexes) - 1; i >= 0; i-- {
+			if exclusive {
+				mutexes[i].Unlock()
+			} else {
+				mutexes[i].RUnlock()
+			}
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(b.dataDir, lockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	err = flock(file, exclusive)
+	if err != nil {
+		file.Close()
+		release()
+		return nil, err
+	}
+
+	err = b.db.Refresh()
+	if err != nil {
+		funlock(file)
+		file.Close()
+		release()
+		return nil, err
+	}
+
+	return func() {
+		funlock(file)
+		file.Close()
+		release()
+	}, nil
+}
+
+// lockDataDir takes the advisory lock on dataDir exclusively, for work on
+// the directory as a whole while no server is using it.
+func lockDataDir(dataDir string) (func(), error) {
+	file, err := os.OpenFile(filepath.Join(dataDir, lockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = flock(file, true)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() {
+		funlock(file)
+		file.Close()
+	}, nil
+}
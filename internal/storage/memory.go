@@ -0,0 +1,685 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"triple-s/internal/structure"
+)
+
+type memoryBucket struct {
+	info    structure.Bucket
+	objects map[string]*memoryObject
+	uploads map[string]*memoryUpload
+
+	// history holds noncurrent versions and delete markers, oldest first.
+	history []*memoryObject
+}
+
+type memoryObject struct {
+	info structure.Object
+	data []byte
+}
+
+type memoryUpload struct {
+	info  structure.Upload
+	parts map[int]*memoryPart
+}
+
+type memoryPart struct {
+	info structure.Part
+	data []byte
+}
+
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	buckets map[string]*memoryBucket
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+func (m *MemoryBackend) CreateBucket(bucket structure.Bucket) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.buckets[bucket.Name]; ok {
+		return ErrBucketExists
+	}
+
+	bucket.CreationTime = time.Now()
+	bucket.LastModified = time.Now()
+	bucket.Status = "active"
+
+	m.buckets[bucket.Name] = &memoryBucket{
+		info:    bucket,
+		objects: make(map[string]*memoryObject),
+		uploads: make(map[string]*memoryUpload),
+	}
+	return nil
+}
+
+func (m *MemoryBackend) BucketExists(bucketName string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.buckets[bucketName]
+	return ok, nil
+}
+
+func (m *MemoryBackend) GetBucket(bucketName string) (*structure.Bucket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+
+	info := bucket.info
+	return &info, nil
+}
+
+func (m *MemoryBackend) UpdateBucket(bucketName string, update func(*structure.Bucket) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.buckets[bucketName]
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	bucket := existing.info
+	err := update(&bucket)
+	if err != nil {
+		return err
+	}
+	bucket.Name = bucketName
+	bucket.LastModified = time.Now()
+	existing.info = bucket
+	return nil
+}
+
+func (m *MemoryBackend) ListBuckets() ([]structure.Bucket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	buckets := make([]structure.Bucket, 0, len(m.buckets))
+	for _, bucket := range m.buckets {
+		buckets = append(buckets, bucket.info)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Name < buckets[j].Name
+	})
+
+	return buckets, nil
+}
+
+func (m *MemoryBackend) DeleteBucket(bucketName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	if len(bucket.objects) > 0 || len(bucket.history) > 0 {
+		return ErrBucketNotEmpty
+	}
+
+	delete(m.buckets, bucketName)
+	return nil
+}
+
+func (m *MemoryBackend) IsBucketEmpty(bucketName string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return true, nil
+	}
+	return len(bucket.objects) == 0 && len(bucket.history) == 0, nil
+}
+
+func (m *MemoryBackend) StoreObject(bucketName, objectKey string, data io.Reader, object *structure.Object, createOnly bool) error {
+	stored, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(stored)
+	object.Size = int64(len(stored))
+	object.ETag = hex.EncodeToString(sum[:])
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	if _, ok := bucket.objects[objectKey]; ok && createOnly {
+		return ErrObjectExists
+	}
+
+	bucket.put(&memoryObject{
+		info: *object,
+		data: stored,
+	})
+	return nil
+}
+
+func (m *MemoryBackend) ObjectExists(bucketName, objectKey string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.lookup(bucketName, objectKey)
+	return ok, nil
+}
+
+func (m *MemoryBackend) GetObject(bucketName, objectKey string) (*structure.Object, io.ReadSeekCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.lookup(bucketName, objectKey)
+	if !ok {
+		return nil, nil, ErrObjectNotFound
+	}
+
+	info := object.info
+	return &info, nopCloser{bytes.NewReader(object.data)}, nil
+}
+
+func (m *MemoryBackend) GetObjectMetadata(bucketName, objectKey string) (*structure.Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.lookup(bucketName, objectKey)
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+
+	info := object.info
+	return &info, nil
+}
+
+func (m *MemoryBackend) UpdateObject(bucketName, objectKey string, update func(*structure.Object) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.lookup(bucketName, objectKey)
+	if !ok {
+		return ErrObjectNotFound
+	}
+
+	object, err := updatedObject(existing.info, update)
+	if err != nil {
+		return err
+	}
+	existing.info = object
+	return nil
+}
+
+func (m *MemoryBackend) ListObjects(bucketName string) ([]structure.Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return []structure.Object{}, nil
+	}
+
+	objects := make([]structure.Object, 0, len(bucket.objects))
+	for _, object := range bucket.objects {
+		objects = append(objects, object.info)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].ObjectKey < objects[j].ObjectKey
+	})
+
+	return objects, nil
+}
+
+func (m *MemoryBackend) WalkObjects(bucketName, prefix, startAfter string, fn func(structure.Object) bool) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	var keys []string
+	for key := range bucket.objects {
+		if key > startAfter && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !fn(bucket.objects[key].info) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBackend) DeleteObject(bucketName, objectKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return ErrObjectNotFound
+	}
+	if _, ok := bucket.objects[objectKey]; !ok {
+		return ErrObjectNotFound
+	}
+
+	delete(bucket.objects, objectKey)
+	return nil
+}
+
+func (m *MemoryBackend) CreateMultipartUpload(bucketName string, object structure.Object) (string, error) {
+	uploadID, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return "", ErrBucketNotFound
+	}
+
+	bucket.uploads[uploadID] = &memoryUpload{
+		info: structure.Upload{
+			Key:           object.ObjectKey,
+			UploadID:      uploadID,
+			Initiated:     time.Now(),
+			ContentType:   object.ContentType,
+			ACL:           object.ACL,
+			Tagging:       object.Tagging,
+			ObjectHeaders: object.ObjectHeaders,
+		},
+		parts: make(map[int]*memoryPart),
+	}
+	return uploadID, nil
+}
+
+func (m *MemoryBackend) UploadPart(bucketName, objectKey, uploadID string, partNumber int, data io.Reader) (*structure.Part, error) {
+	stored, err := io.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(stored)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, err := m.upload(bucketName, objectKey, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	part := structure.Part{
+		PartNumber:   partNumber,
+		LastModified: time.Now(),
+		ETag:         hex.EncodeToString(sum[:]),
+		Size:         int64(len(stored)),
+	}
+	upload.parts[partNumber] = &memoryPart{
+		info: part,
+		data: stored,
+	}
+	return &part, nil
+}
+
+func (m *MemoryBackend) ListParts(bucketName, objectKey, uploadID string) ([]structure.Part, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	upload, err := m.upload(bucketName, objectKey, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	return upload.partList(), nil
+}
+
+func (m *MemoryBackend) CompleteMultipartUpload(bucketName, objectKey, uploadID string, parts []structure.CompletedPart, versionID string) (*structure.Object, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, err := m.upload(bucketName, objectKey, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	selected, etag, err := selectParts(parts, upload.partList())
+	if err != nil {
+		return nil, err
+	}
+
+	var data bytes.Buffer
+	for _, part := range selected {
+		data.Write(upload.parts[part.PartNumber].data)
+	}
+
+	object := structure.Object{
+		ObjectKey:     objectKey,
+		Size:          int64(data.Len()),
+		ContentType:   upload.info.ContentType,
+		LastModified:  time.Now(),
+		ETag:          etag,
+		ACL:           upload.info.ACL,
+		Tagging:       upload.info.Tagging,
+		ObjectHeaders: upload.info.ObjectHeaders,
+		VersionID:     versionID,
+	}
+
+	bucket := m.buckets[bucketName]
+	bucket.put(&memoryObject{
+		info: object,
+		data: data.Bytes(),
+	})
+	delete(bucket.uploads, uploadID)
+
+	return &object, nil
+}
+
+func (m *MemoryBackend) AbortMultipartUpload(bucketName, objectKey, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.upload(bucketName, objectKey, uploadID)
+	if err != nil {
+		return err
+	}
+
+	delete(m.buckets[bucketName].uploads, uploadID)
+	return nil
+}
+
+func (m *MemoryBackend) ListMultipartUploads(bucketName string) ([]structure.Upload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return []structure.Upload{}, nil
+	}
+
+	uploads := make([]structure.Upload, 0, len(bucket.uploads))
+	for _, upload := range bucket.uploads {
+		uploads = append(uploads, upload.info)
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].Initiated.Before(uploads[j].Initiated)
+	})
+
+	return uploads, nil
+}
+
+func (m *MemoryBackend) upload(bucketName, objectKey, uploadID string) (*memoryUpload, error) {
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, ErrNoSuchUpload
+	}
+	upload, ok := bucket.uploads[uploadID]
+	if !ok || upload.info.Key != objectKey {
+		return nil, ErrNoSuchUpload
+	}
+	return upload, nil
+}
+
+func (u *memoryUpload) partList() []structure.Part {
+	parts := make([]structure.Part, 0, len(u.parts))
+	for _, part := range u.parts {
+		parts = append(parts, part.info)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func (m *MemoryBackend) lookup(bucketName, objectKey string) (*memoryObject, bool) {
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, false
+	}
+	object, ok := bucket.objects[objectKey]
+	return object, ok
+}
+
+// put makes object the current version of its key, keeping the version it
+// replaces in the history when object carries a version ID. The rules match
+// FileBackend.archiveCurrent.
+func (b *memoryBucket) put(object *memoryObject) {
+	key := object.info.ObjectKey
+	if object.info.VersionID != "" {
+		b.archiveCurrent(key, object.info.VersionID)
+	}
+	b.objects[key] = object
+}
+
+func (b *memoryBucket) archiveCurrent(key, newVersionID string) {
+	if isNullVersion(newVersionID) {
+		kept := b.history[:0]
+		for _, version := range b.history {
+			if version.info.ObjectKey != key || !isNullVersion(version.info.VersionID) {
+				kept = append(kept, version)
+			}
+		}
+		b.history = kept
+	}
+
+	current, ok := b.objects[key]
+	if !ok || (isNullVersion(current.info.VersionID) && isNullVersion(newVersionID)) {
+		return
+	}
+
+	archived := *current
+	archived.info.VersionID = reportedVersion(current.info.VersionID)
+	b.history = append(b.history, &archived)
+}
+
+// promoteLatest restores the newest version of key from the history when
+// it is not a delete marker.
+func (b *memoryBucket) promoteLatest(key string) {
+	latest := -1
+	for i, version := range b.history {
+		if version.info.ObjectKey == key {
+			latest = i
+		}
+	}
+	if latest < 0 || b.history[latest].info.DeleteMarker {
+		return
+	}
+
+	b.objects[key] = b.history[latest]
+	b.history = append(b.history[:latest], b.history[latest+1:]...)
+}
+
+// findVersion returns the current object or history entry of key with
+// versionID.
+func (b *memoryBucket) findVersion(key, versionID string) (*memoryObject, bool) {
+	if current, ok := b.objects[key]; ok && sameVersion(current.info.VersionID, versionID) {
+		return current, true
+	}
+	for _, version := range b.history {
+		if version.info.ObjectKey == key && sameVersion(version.info.VersionID, versionID) {
+			return version, true
+		}
+	}
+	return nil, false
+}
+
+func (m *MemoryBackend) PutDeleteMarker(bucketName, objectKey, versionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	bucket.putDeleteMarker(objectKey, versionID)
+	return nil
+}
+
+func (m *MemoryBackend) ExpireObject(bucketName string, observed structure.Object, versionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	current, ok := bucket.objects[observed.ObjectKey]
+	if !ok || !sameObject(current.info, observed) {
+		return ErrObjectChanged
+	}
+
+	if versionID != "" {
+		bucket.putDeleteMarker(observed.ObjectKey, versionID)
+	} else {
+		delete(bucket.objects, observed.ObjectKey)
+	}
+	return nil
+}
+
+func (b *memoryBucket) putDeleteMarker(objectKey, versionID string) {
+	b.archiveCurrent(objectKey, versionID)
+	delete(b.objects, objectKey)
+	b.history = append(b.history, &memoryObject{
+		info: structure.Object{
+			ObjectKey:    objectKey,
+			LastModified: time.Now(),
+			VersionID:    reportedVersion(versionID),
+			DeleteMarker: true,
+		},
+	})
+}
+
+func (m *MemoryBackend) GetObjectVersion(bucketName, objectKey, versionID string) (*structure.Object, io.ReadSeekCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, nil, ErrNoSuchVersion
+	}
+	version, ok := bucket.findVersion(objectKey, versionID)
+	if !ok {
+		return nil, nil, ErrNoSuchVersion
+	}
+
+	info := version.info
+	info.IsLatest = bucket.objects[objectKey] == version
+	if info.DeleteMarker {
+		return &info, nil, nil
+	}
+	return &info, nopCloser{bytes.NewReader(version.data)}, nil
+}
+
+func (m *MemoryBackend) GetObjectVersionMetadata(bucketName, objectKey, versionID string) (*structure.Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, ErrNoSuchVersion
+	}
+	version, ok := bucket.findVersion(objectKey, versionID)
+	if !ok {
+		return nil, ErrNoSuchVersion
+	}
+
+	info := version.info
+	info.IsLatest = bucket.objects[objectKey] == version
+	return &info, nil
+}
+
+func (m *MemoryBackend) DeleteObjectVersion(bucketName, objectKey, versionID string) (*structure.Object, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, ErrNoSuchVersion
+	}
+
+	if current, ok := bucket.objects[objectKey]; ok && sameVersion(current.info.VersionID, versionID) {
+		delete(bucket.objects, objectKey)
+		bucket.promoteLatest(objectKey)
+		info := current.info
+		return &info, nil
+	}
+
+	latest, found := -1, -1
+	for i, version := range bucket.history {
+		if version.info.ObjectKey != objectKey {
+			continue
+		}
+		latest = i
+		if sameVersion(version.info.VersionID, versionID) {
+			found = i
+		}
+	}
+	if found < 0 {
+		return nil, ErrNoSuchVersion
+	}
+
+	removed := bucket.history[found].info
+	bucket.history = append(bucket.history[:found], bucket.history[found+1:]...)
+	if _, ok := bucket.objects[objectKey]; !ok && found == latest {
+		bucket.promoteLatest(objectKey)
+	}
+	return &removed, nil
+}
+
+func (m *MemoryBackend) ListObjectVersions(bucketName string) ([]structure.Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return []structure.Object{}, nil
+	}
+
+	objects := make([]structure.Object, 0, len(bucket.objects))
+	for _, object := range bucket.objects {
+		objects = append(objects, object.info)
+	}
+	history := make([]structure.Object, 0, len(bucket.history))
+	for _, version := range bucket.history {
+		history = append(history, version.info)
+	}
+
+	return sortVersions(objects, history), nil
+}
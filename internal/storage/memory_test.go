@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"triple-s/internal/structure"
+)
+
+// TestMemoryBackendBuckets checks that the memory backend lists buckets and
+// reports missing ones the way the file backend does.
+func TestMemoryBackendBuckets(t *testing.T) {
+	m := NewMemoryBackend()
+	created := time.Now()
+	for i, name := range []string{"charlie", "alpha", "bravo"} {
+		err := m.CreateBucket(structure.Bucket{Name: name, CreationTime: created.Add(time.Duration(i) * time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buckets, err := m.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, bucket := range buckets {
+		names = append(names, bucket.Name)
+	}
+	if strings.Join(names, ",") != "alpha,bravo,charlie" {
+		t.Errorf("ListBuckets() = %v, want them by name", names)
+	}
+
+	err = m.StoreObject("missing", "key", strings.NewReader("data"), &structure.Object{ObjectKey: "key"}, false)
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("StoreObject: %v, want %v", err, ErrBucketNotFound)
+	}
+	err = m.PutDeleteMarker("missing", "key", "")
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("PutDeleteMarker: %v, want %v", err, ErrBucketNotFound)
+	}
+	_, err = m.CreateMultipartUpload("missing", structure.Object{ObjectKey: "key"})
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("CreateMultipartUpload: %v, want %v", err, ErrBucketNotFound)
+	}
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"triple-s/internal/kv"
+	"triple-s/internal/structure"
+)
+
+// metadataDB holds the metadata of every bucket, object and version in the
+// data directory. Records are JSON and keyed so that each bucket's objects,
+// and each key's history, are contiguous and in order:
+//
+//	bucket/<bucket>                          bucket attributes
+//	object/<bucket>/<key>                    current version of an object
+//	version/<bucket>/<key>\x00<sequence>     noncurrent versions, oldest first
+//	wal/<sequence>                           unfinished operations, see wal.go
+//
+// Bucket names cannot contain a slash and object keys cannot contain a NUL
+// byte, so no prefix of one record is the prefix of another bucket or key.
+const metadataDB = "metadata.db"
+
+const (
+	bucketPrefix  = "bucket/"
+	objectPrefix  = "object/"
+	versionPrefix = "version/"
+)
+
+func bucketKey(bucketName string) string {
+	return bucketPrefix + bucketName
+}
+
+func objectsPrefix(bucketName string) string {
+	return objectPrefix + bucketName + "/"
+}
+
+func versionsPrefix(bucketName string) string {
+	return versionPrefix + bucketName + "/"
+}
+
+func historyPrefix(bucketName, objectKey string) string {
+	return versionsPrefix(bucketName) + objectKey + "\x00"
+}
+
+func getBucket(tx *kv.Tx, bucketName string) (*structure.Bucket, error) {
+	value, ok := tx.Get(bucketKey(bucketName))
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+
+	bucket := &structure.Bucket{}
+	err := json.Unmarshal(value, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("bucket %s: %w", bucketName, err)
+	}
+	return bucket, nil
+}
+
+func putBucket(tx *kv.Tx, bucket structure.Bucket) error {
+	value, err := json.Marshal(bucket)
+	if err != nil {
+		return err
+	}
+	return tx.Put(bucketKey(bucket.Name), value)
+}
+
+func listBuckets(tx *kv.Tx) ([]structure.Bucket, error) {
+	buckets := []structure.Bucket{}
+	var err error
+	tx.Scan(bucketPrefix, func(key string, value []byte) bool {
+		var bucket structure.Bucket
+		err = json.Unmarshal(value, &bucket)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", key, err)
+			return false
+		}
+		buckets = append(buckets, bucket)
+		return true
+	})
+	return buckets, err
+}
+
+// getObject returns the current version of objectKey, or nil if there is
+// none.
+func getObject(tx *kv.Tx, bucketName, objectKey string) (*structure.Object, error) {
+	value, ok := tx.Get(objectsPrefix(bucketName) + objectKey)
+	if !ok {
+		return nil, nil
+	}
+	return decodeObject(objectKey, value)
+}
+
+func putObject(tx *kv.Tx, bucketName string, object structure.Object) error {
+	object.IsLatest = false
+	value, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return tx.Put(objectsPrefix(bucketName)+object.ObjectKey, value)
+}
+
+func deleteObject(tx *kv.Tx, bucketName, objectKey string) error {
+	return tx.Delete(objectsPrefix(bucketName) + objectKey)
+}
+
+// listObjects returns the current objects of a bucket in key order.
+func listObjects(tx *kv.Tx, bucketName string) ([]structure.Object, error) {
+	return scanObjects(tx, objectsPrefix(bucketName))
+}
+
+// listHistory returns the noncurrent versions of every key in a bucket,
+// ordered by key and then from oldest to newest.
+func listHistory(tx *kv.Tx, bucketName string) ([]structure.Object, error) {
+	return scanObjects(tx, versionsPrefix(bucketName))
+}
+
+func scanObjects(tx *kv.Tx, prefix string) ([]structure.Object, error) {
+	objects := []structure.Object{}
+	var err error
+	tx.Scan(prefix, func(key string, value []byte) bool {
+		var object *structure.Object
+		object, err = decodeObject(key, value)
+		if err != nil {
+			return false
+		}
+		objects = append(objects, *object)
+		return true
+	})
+	return objects, err
+}
+
+func decodeObject(key string, value []byte) (*structure.Object, error) {
+	object := &structure.Object{}
+	err := json.Unmarshal(value, object)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", key, err)
+	}
+	return object, nil
+}
+
+// historyEntry is a noncurrent version together with the database key it
+// is stored under.
+type historyEntry struct {
+	key     string
+	version structure.Object
+}
+
+// keyHistory returns the noncurrent versions of objectKey from oldest to
+// newest.
+func keyHistory(tx *kv.Tx, bucketName, objectKey string) ([]historyEntry, error) {
+	history := []historyEntry{}
+	var err error
+	tx.Scan(historyPrefix(bucketName, objectKey), func(key string, value []byte) bool {
+		var version *structure.Object
+		version, err = decodeObject(key, value)
+		if err != nil {
+			return false
+		}
+		history = append(history, historyEntry{key, *version})
+		return true
+	})
+	return history, err
+}
+
+// appendHistory adds version after the newest noncurrent version of its key.
+func appendHistory(tx *kv.Tx, bucketName string, version structure.Object) error {
+	history, err := keyHistory(tx, bucketName, version.ObjectKey)
+	if err != nil {
+		return err
+	}
+
+	var sequence uint64
+	if len(history) > 0 {
+		last := history[len(history)-1].key
+		sequence, err = strconv.ParseUint(last[strings.LastIndexByte(last, 0)+1:], 16, 64)
+		if err != nil {
+			return fmt.Errorf("%q: %w", last, err)
+		}
+		sequence++
+	}
+
+	version.IsLatest = false
+	value, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	return tx.Put(fmt.Sprintf("%s%016x", historyPrefix(bucketName, version.ObjectKey), sequence), value)
+}
+
+// deleteBucketRecords removes a bucket along with the records of all of its
+// objects and versions.
+func deleteBucketRecords(tx *kv.Tx, bucketName string) error {
+	var keys []string
+	for _, prefix := range []string{objectsPrefix(bucketName), versionsPrefix(bucketName)} {
+		tx.Scan(prefix, func(key string, value []byte) bool {
+			keys = append(keys, key)
+			return true
+		})
+	}
+	keys = append(keys, bucketKey(bucketName))
+
+	for _, key := range keys {
+		err := tx.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
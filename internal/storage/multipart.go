@@ -0,0 +1,552 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"triple-s/internal/structure"
+)
+
+const (
+	multipartDir = ".multipart"
+	uploadCSV    = "upload.csv"
+	partsCSV     = "parts.csv"
+
+	MinPartSize   = 5 << 20
+	MaxPartNumber = 10000
+)
+
+func newUploadID() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func validUploadID(uploadID string) bool {
+	decoded, err := hex.DecodeString(uploadID)
+	return err == nil && len(decoded) == 16
+}
+
+// selectParts matches the part list sent with CompleteMultipartUpload against
+// the parts that were actually uploaded and computes the S3-style
+// "md5-of-md5s-N" ETag of the assembled object.
+func selectParts(requested []structure.CompletedPart, uploaded []structure.Part) ([]structure.Part, string, error) {
+	if len(requested) == 0 {
+		return nil, "", ErrInvalidPart
+	}
+
+	byNumber := make(map[int]structure.Part, len(uploaded))
+	for _, part := range uploaded {
+		byNumber[part.PartNumber] = part
+	}
+
+	hash := md5.New()
+	selected := make([]structure.Part, 0, len(requested))
+	previous := 0
+	for i, requestedPart := range requested {
+		if requestedPart.PartNumber <= previous {
+			return nil, "", ErrInvalidPartOrder
+		}
+		previous = requestedPart.PartNumber
+
+		part, ok := byNumber[requestedPart.PartNumber]
+		if !ok || strings.Trim(requestedPart.ETag, `"`) != part.ETag {
+			return nil, "", ErrInvalidPart
+		}
+		if i < len(requested)-1 && part.Size < MinPartSize {
+			return nil, "", ErrEntityTooSmall
+		}
+
+		sum, err := hex.DecodeString(part.ETag)
+		if err != nil {
+			return nil, "", ErrInvalidPart
+		}
+		hash.Write(sum)
+		selected = append(selected, part)
+	}
+
+	etag := fmt.Sprintf("%s-%d", hex.EncodeToString(hash.Sum(nil)), len(selected))
+	return selected, etag, nil
+}
+
+func (b *FileBackend) uploadDir(bucketName, uploadID string) string {
+	return filepath.Join(b.dataDir, bucketName, multipartDir, uploadID)
+}
+
+func partFileName(partNumber int) string {
+	return fmt.Sprintf("%05d", partNumber)
+}
+
+func (b *FileBackend) CreateMultipartUpload(bucketName string, object structure.Object) (string, error) {
+	uploadID, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+
+	unlock, err := b.lock(true, bucketName)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	uploadDir := b.uploadDir(bucketName, uploadID)
+	err = os.MkdirAll(uploadDir, 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	err = writeFileAtomic(filepath.Join(uploadDir, uploadCSV), func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"ObjectKey", "ContentType", "Initiated", "ACL", "Tagging",
+			"ContentDisposition", "ContentEncoding", "CacheControl", "Expires", "Metadata"})
+		record := []string{object.ObjectKey, object.ContentType, time.Now().Format(time.RFC3339), object.ACL, object.Tagging}
+		writer.Write(append(record, headerColumns(object.ObjectHeaders)...))
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		os.RemoveAll(uploadDir)
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+func (b *FileBackend) UploadPart(bucketName, objectKey, uploadID string, partNumber int, data io.Reader) (*structure.Part, error) {
+	_, err := b.checkUpload(bucketName, objectKey, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadDir := b.uploadDir(bucketName, uploadID)
+
+	var written structure.Object
+	tmpPath, err := writeTempFile(uploadDir, data, &written)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+
+	unlock, err := b.lock(true, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	_, err = b.readUpload(bucketName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmpPath, filepath.Join(uploadDir, partFileName(partNumber)))
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := b.readParts(bucketName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	part := structure.Part{
+		PartNumber:   partNumber,
+		LastModified: time.Now(),
+		ETag:         written.ETag,
+		Size:         written.Size,
+	}
+
+	filteredParts := []structure.Part{part}
+	for _, existing := range parts {
+		if existing.PartNumber != partNumber {
+			filteredParts = append(filteredParts, existing)
+		}
+	}
+
+	err = b.writeParts(bucketName, uploadID, filteredParts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &part, nil
+}
+
+func (b *FileBackend) ListParts(bucketName, objectKey, uploadID string) ([]structure.Part, error) {
+	unlock, err := b.lock(false, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	_, err = b.checkUpload(bucketName, objectKey, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.readParts(bucketName, uploadID)
+}
+
+func (b *FileBackend) CompleteMultipartUpload(bucketName, objectKey, uploadID string, parts []structure.CompletedPart, versionID string) (*structure.Object, error) {
+	tmpPath, object, err := b.assembleUpload(bucketName, objectKey, uploadID, parts)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+	object.VersionID = versionID
+
+	unlock, err := b.lock(true, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	_, err = b.readUpload(bucketName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.commitObject(bucketName, objectKey, tmpPath, object, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.removeUpload(bucketName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	return object, nil
+}
+
+// assembleUpload concatenates the selected parts into a temporary file in
+// the bucket directory. It holds the bucket lock in shared mode so that no
+// part can be replaced while it is being copied.
+func (b *FileBackend) assembleUpload(bucketName, objectKey, uploadID string, requested []structure.CompletedPart) (string, *structure.Object, error) {
+	unlock, err := b.lock(false, bucketName)
+	if err != nil {
+		return "", nil, err
+	}
+	defer unlock()
+
+	upload, err := b.checkUpload(bucketName, objectKey, uploadID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	uploaded, err := b.readParts(bucketName, uploadID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	selected, etag, err := selectParts(requested, uploaded)
+	if err != nil {
+		return "", nil, err
+	}
+
+	tmp, err := createObjectTemp(filepath.Join(b.dataDir, bucketName))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var size int64
+	uploadDir := b.uploadDir(bucketName, uploadID)
+	for _, part := range selected {
+		var n int64
+		n, err = appendFile(tmp, filepath.Join(uploadDir, partFileName(part.PartNumber)))
+		if err != nil {
+			break
+		}
+		size += n
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+
+	object := &structure.Object{
+		ObjectKey:     objectKey,
+		Size:          size,
+		ContentType:   upload.ContentType,
+		LastModified:  time.Now(),
+		ETag:          etag,
+		ACL:           upload.ACL,
+		Tagging:       upload.Tagging,
+		ObjectHeaders: upload.ObjectHeaders,
+	}
+	return tmp.Name(), object, nil
+}
+
+func appendFile(dst io.Writer, path string) (int64, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	return io.Copy(dst, src)
+}
+
+func (b *FileBackend) AbortMultipartUpload(bucketName, objectKey, uploadID string) error {
+	unlock, err := b.lock(true, bucketName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = b.checkUpload(bucketName, objectKey, uploadID)
+	if err != nil {
+		return err
+	}
+
+	return b.removeUpload(bucketName, uploadID)
+}
+
+func (b *FileBackend) removeUpload(bucketName, uploadID string) error {
+	err := os.RemoveAll(b.uploadDir(bucketName, uploadID))
+	if err != nil {
+		return err
+	}
+
+	os.Remove(filepath.Join(b.dataDir, bucketName, multipartDir))
+	return nil
+}
+
+func (b *FileBackend) ListMultipartUploads(bucketName string) ([]structure.Upload, error) {
+	unlock, err := b.lock(false, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err := os.ReadDir(filepath.Join(b.dataDir, bucketName, multipartDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []structure.Upload{}, nil
+		}
+		return nil, err
+	}
+
+	uploads := []structure.Upload{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !validUploadID(entry.Name()) {
+			continue
+		}
+
+		upload, err := b.readUpload(bucketName, entry.Name())
+		if err != nil {
+			log.Printf("Failed to read multipart upload %s: %v", entry.Name(), err)
+			continue
+		}
+		uploads = append(uploads, *upload)
+	}
+
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].Initiated.Before(uploads[j].Initiated)
+	})
+
+	return uploads, nil
+}
+
+func (b *FileBackend) checkUpload(bucketName, objectKey, uploadID string) (*structure.Upload, error) {
+	upload, err := b.readUpload(bucketName, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Key != objectKey {
+		return nil, ErrNoSuchUpload
+	}
+	return upload, nil
+}
+
+func (b *FileBackend) readUpload(bucketName, uploadID string) (*structure.Upload, error) {
+	if !validUploadID(uploadID) {
+		return nil, ErrNoSuchUpload
+	}
+
+	file, err := os.Open(filepath.Join(b.uploadDir(bucketName, uploadID), uploadCSV))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoSuchUpload
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 || len(records[1]) < 3 {
+		return nil, fmt.Errorf("malformed %s for upload %s", uploadCSV, uploadID)
+	}
+
+	initiated, err := time.Parse(time.RFC3339, records[1][2])
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &structure.Upload{
+		Key:         records[1][0],
+		UploadID:    uploadID,
+		Initiated:   initiated,
+		ContentType: records[1][1],
+	}
+	if len(records[1]) > 3 {
+		upload.ACL = records[1][3]
+	}
+	if len(records[1]) > 4 {
+		upload.Tagging = records[1][4]
+	}
+	upload.ObjectHeaders = parseHeaderColumns(records[1], 5)
+	return upload, nil
+}
+
+func (b *FileBackend) readParts(bucketName, uploadID string) ([]structure.Part, error) {
+	file, err := os.Open(filepath.Join(b.uploadDir(bucketName, uploadID), partsCSV))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []structure.Part{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []structure.Part{}
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && record[0] == "PartNumber" {
+			continue
+		}
+		if len(record) < 4 {
+			log.Printf("Not enough fields in line %d: expected 4, got %d", i+1, len(record))
+			continue
+		}
+
+		partNumber, err := strconv.Atoi(record[0])
+		if err != nil {
+			log.Printf("Failed to parse PartNumber in line %d: %v", i+1, err)
+			continue
+		}
+		size, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			log.Printf("Failed to parse Size in line %d: %v", i+1, err)
+			continue
+		}
+		lastModified, err := time.Parse(time.RFC3339, record[3])
+		if err != nil {
+			log.Printf("Failed to parse LastModified in line %d: %v", i+1, err)
+			continue
+		}
+
+		parts = append(parts, structure.Part{
+			PartNumber:   partNumber,
+			Size:         size,
+			ETag:         record[2],
+			LastModified: lastModified,
+		})
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	return parts, nil
+}
+
+func (b *FileBackend) writeParts(bucketName, uploadID string, parts []structure.Part) error {
+	csvPath := filepath.Join(b.uploadDir(bucketName, uploadID), partsCSV)
+
+	return writeFileAtomic(csvPath, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"PartNumber", "Size", "ETag", "LastModified"})
+
+		for _, part := range parts {
+			record := []string{
+				strconv.Itoa(part.PartNumber),
+				strconv.FormatInt(part.Size, 10),
+				part.ETag,
+				part.LastModified.Format(time.RFC3339),
+			}
+			writer.Write(record)
+		}
+
+		writer.Flush()
+		return writer.Error()
+	})
+}
+
+// headerColumns flattens headers into the trailing columns of upload.csv.
+// User metadata is stored URL-encoded in a single column.
+func headerColumns(headers structure.ObjectHeaders) []string {
+	metadata := url.Values{}
+	for name, value := range headers.Metadata {
+		metadata.Set(name, value)
+	}
+
+	return []string{
+		headers.ContentDisposition,
+		headers.ContentEncoding,
+		headers.CacheControl,
+		headers.Expires,
+		metadata.Encode(),
+	}
+}
+
+// parseHeaderColumns reads the columns written by headerColumns starting at
+// index first. Missing columns, as in files written by older versions, are
+// left empty.
+func parseHeaderColumns(record []string, first int) structure.ObjectHeaders {
+	column := func(i int) string {
+		if first+i < len(record) {
+			return record[first+i]
+		}
+		return ""
+	}
+
+	headers := structure.ObjectHeaders{
+		ContentDisposition: column(0),
+		ContentEncoding:    column(1),
+		CacheControl:       column(2),
+		Expires:            column(3),
+	}
+
+	metadata, err := url.ParseQuery(column(4))
+	if err == nil && len(metadata) > 0 {
+		headers.Metadata = make(map[string]string, len(metadata))
+		for name := range metadata {
+			headers.Metadata[name] = metadata.Get(name)
+		}
+	}
+	return headers
+}
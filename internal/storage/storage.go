@@ -1,451 +1,470 @@
 package storage
 
 import (
-	"encoding/csv"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 	"time"
 
+	"triple-s/internal/kv"
 	"triple-s/internal/structure"
 )
 
-const (
-	bucketsCSV = "buckets.csv"
-	objectsCSV = "objects.csv"
-)
-
-func CreateBucket(dataDir, bucketName string) error {
-	bucketDir := filepath.Join(dataDir, bucketName)
-	err := os.MkdirAll(bucketDir, 0o755)
-	if err != nil {
-		return err
-	}
-
-	bucket := structure.Bucket{
-		Name:         bucketName,
-		CreationTime: time.Now(),
-		LastModified: time.Now(),
-		Status:       "active",
-	}
-
-	return addBucketToCSV(dataDir, bucket)
+type FileBackend struct {
+	dataDir string
+	locks   *lockRegistry
+	db      *kv.DB
 }
 
-func BucketExists(dataDir, bucketName string) (bool, error) {
-	buckets, err := ListBuckets(dataDir)
-	if err != nil {
-		return false, err
-	}
-
-	for _, bucket := range buckets {
-		if bucket.Name == bucketName {
-			return true, nil
-		}
+// NewFileBackend opens the metadata database in dataDir. It refuses a
+// directory in a format other than FormatVersion.
+func NewFileBackend(dataDir string) (*FileBackend, error) {
+	b := &FileBackend{
+		dataDir: dataDir,
+		locks:   newLockRegistry(),
 	}
 
-	return false, nil
-}
-
-func ListBuckets(dataDir string) ([]structure.Bucket, error) {
-	csvPath := filepath.Join(dataDir, bucketsCSV)
-
-	file, err := os.Open(csvPath)
+	err := b.open()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []structure.Bucket{}, nil
-		}
 		return nil, err
 	}
-	defer file.Close()
+	return b, nil
+}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+func (b *FileBackend) open() error {
+	unlock, err := lockDataDir(b.dataDir)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer unlock()
 
-	buckets := []structure.Bucket{}
-	for i, record := range records {
-		if i == 0 && len(record) > 0 && record[0] == "Name" {
-			continue
-		}
-		if len(record) < 4 {
-			log.Printf("Not enough fields in line %d: expected 4, got %d", i+1, len(record))
-			continue
-		}
-
-		creationTime, err := time.Parse(time.RFC3339, record[1])
-		if err != nil {
-			log.Printf("Failed to parse CreationTime in line %d: %v", i+1, err)
-			continue
-		}
-		modifiedTime, err := time.Parse(time.RFC3339, record[2])
-		if err != nil {
-			log.Printf("Failed to parse ModifiedTime in line %d: %v", i+1, err)
-			continue
-		}
-
-		bucket := structure.Bucket{
-			Name:         record[0],
-			CreationTime: creationTime,
-			LastModified: modifiedTime,
-			Status:       record[3],
-		}
-		buckets = append(buckets, bucket)
+	err = checkFormat(b.dataDir)
+	if err != nil {
+		return err
 	}
 
-	return buckets, nil
-}
-
-func DeleteBucket(dataDir, bucketName string) error {
-	bucketDir := filepath.Join(dataDir, bucketName)
-	err := os.RemoveAll(bucketDir)
+	b.db, err = kv.Open(filepath.Join(b.dataDir, metadataDB))
 	if err != nil {
 		return err
 	}
 
-	return removeBucketFromCSV(dataDir, bucketName)
+	return b.recover()
 }
 
-func removeBucketFromCSV(dataDir, bucketName string) error {
-	csvPath := filepath.Join(dataDir, bucketsCSV)
-
-	buckets, err := ListBuckets(dataDir)
+// CreateBucket adds a new bucket. It returns ErrBucketExists if one with
+// the same name is already recorded.
+func (b *FileBackend) CreateBucket(bucket structure.Bucket) error {
+	unlock, err := b.lock(true, catalogLock)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	filteredBuckets := []structure.Bucket{}
-	for _, bucket := range buckets {
-		if bucket.Name != bucketName {
-			filteredBuckets = append(filteredBuckets, bucket)
+	err = b.db.View(func(tx *kv.Tx) error {
+		_, err := getBucket(tx, bucket.Name)
+		if err == nil {
+			return ErrBucketExists
 		}
-	}
-
-	file, err := os.Create(csvPath)
+		if errors.Is(err, ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	bucket.CreationTime = time.Now()
+	bucket.LastModified = time.Now()
+	bucket.Status = "active"
 
-	writer.Write([]string{"Name", "CreationTime", "LastModifiedTime", "Status"})
+	return b.execute(&intent{
+		Op:     opCreateBucket,
+		Bucket: bucket.Name,
+		Record: &bucket,
+	})
+}
 
-	for _, bucket := range filteredBuckets {
-		record := []string{
-			bucket.Name,
-			bucket.CreationTime.Format(time.RFC3339),
-			bucket.LastModified.Format(time.RFC3339),
-			bucket.Status,
-		}
-		writer.Write(record)
+func (b *FileBackend) BucketExists(bucketName string) (bool, error) {
+	_, err := b.GetBucket(bucketName)
+	if errors.Is(err, ErrBucketNotFound) {
+		return false, nil
 	}
-
-	return nil
+	return err == nil, err
 }
 
-func addBucketToCSV(dataDir string, bucket structure.Bucket) error {
-	csvPath := filepath.Join(dataDir, bucketsCSV)
-
-	needHeader := false
-	_, err := os.Stat(csvPath)
+func (b *FileBackend) GetBucket(bucketName string) (*structure.Bucket, error) {
+	unlock, err := b.lock(false, catalogLock)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-		needHeader = true
+		return nil, err
 	}
+	defer unlock()
+
+	var bucket *structure.Bucket
+	err = b.db.View(func(tx *kv.Tx) error {
+		bucket, err = getBucket(tx, bucketName)
+		return err
+	})
+	return bucket, err
+}
 
-	file, err := os.OpenFile(csvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+func (b *FileBackend) UpdateBucket(bucketName string, update func(*structure.Bucket) error) error {
+	unlock, err := b.lock(true, catalogLock)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer unlock()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	if needHeader {
-		writer.Write([]string{"Name", "CreationTime", "LastModifiedTime", "Status"})
-	}
+	return b.db.Update(func(tx *kv.Tx) error {
+		bucket, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
 
-	record := []string{
-		bucket.Name,
-		bucket.CreationTime.Format(time.RFC3339),
-		bucket.LastModified.Format(time.RFC3339),
-		bucket.Status,
-	}
-	return writer.Write(record)
+		err = update(bucket)
+		if err != nil {
+			return err
+		}
+		bucket.Name = bucketName
+		bucket.LastModified = time.Now()
+		return putBucket(tx, *bucket)
+	})
 }
 
-func IsBucketEmpty(dataDir, bucketName string) (bool, error) {
-	objects, err := listObjects(dataDir, bucketName)
+func (b *FileBackend) ListBuckets() ([]structure.Bucket, error) {
+	unlock, err := b.lock(false, catalogLock)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	defer unlock()
 
-	if len(objects) == 0 {
-		return true, err
-	}
-	return false, err
+	var buckets []structure.Bucket
+	err = b.db.View(func(tx *kv.Tx) error {
+		buckets, err = listBuckets(tx)
+		return err
+	})
+	return buckets, err
 }
 
-func StoreObject(dataDir, bucketName, objectKey string, data []byte, object structure.Object) error {
-	objectPath := filepath.Join(dataDir, bucketName, objectKey)
-
-	err := os.MkdirAll(filepath.Dir(objectPath), 0o755)
+func (b *FileBackend) DeleteBucket(bucketName string) error {
+	unlock, err := b.lock(true, catalogLock, bucketName)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	err = os.WriteFile(objectPath, data, 0o644)
+	err = b.db.View(func(tx *kv.Tx) error {
+		_, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		if !bucketEmpty(tx, bucketName) {
+			return ErrBucketNotEmpty
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	exists, err := objectExistsInCSV(dataDir, bucketName, objectKey)
+	// The directory is moved aside before the records are deleted and
+	// only removed afterwards, so that it can be put back if the metadata
+	// cannot be updated.
+	id, err := newUploadID()
 	if err != nil {
 		return err
 	}
 
-	if exists {
-		return updateObjectInCSV(dataDir, bucketName, object)
-	}
-
-	return addObjectToCSV(dataDir, bucketName, object)
+	return b.execute(&intent{
+		Op:      opDeleteBucket,
+		Bucket:  bucketName,
+		TmpPath: ".deleted-" + id,
+	})
 }
 
-func objectExistsInCSV(dataDir, bucketName, objectKey string) (bool, error) {
-	objects, err := listObjects(dataDir, bucketName)
+func (b *FileBackend) IsBucketEmpty(bucketName string) (bool, error) {
+	unlock, err := b.lock(false, bucketName)
 	if err != nil {
 		return false, err
 	}
+	defer unlock()
 
-	for _, object := range objects {
-		if object.ObjectKey == objectKey {
-			return true, nil
-		}
-	}
+	empty := true
+	err = b.db.View(func(tx *kv.Tx) error {
+		empty = bucketEmpty(tx, bucketName)
+		return nil
+	})
+	return empty, err
+}
 
-	return false, nil
+// bucketEmpty reports whether a bucket has neither objects nor versions.
+func bucketEmpty(tx *kv.Tx, bucketName string) bool {
+	empty := true
+	for _, prefix := range []string{objectsPrefix(bucketName), versionsPrefix(bucketName)} {
+		tx.Scan(prefix, func(key string, value []byte) bool {
+			empty = false
+			return false
+		})
+	}
+	return empty
 }
 
-func updateObjectInCSV(dataDir, bucketName string, updatedObject structure.Object) error {
-	objects, err := listObjects(dataDir, bucketName)
+func (b *FileBackend) StoreObject(bucketName, objectKey string, data io.Reader, object *structure.Object, createOnly bool) error {
+	bucketDir := filepath.Join(b.dataDir, bucketName)
+
+	tmpPath, err := writeTempFile(bucketDir, data, object)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmpPath)
 
-	for i, object := range objects {
-		if object.ObjectKey == updatedObject.ObjectKey {
-			objects[i] = updatedObject
-			break
-		}
-	}
-
-	csvPath := filepath.Join(dataDir, bucketName, objectsCSV)
-	file, err := os.Create(csvPath)
+	unlock, err := b.lock(true, bucketName)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer unlock()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	writer.Write([]string{"ObjectKey", "Size", "ContentType", "LastModified"})
+	return b.commitObject(bucketName, objectKey, tmpPath, object, createOnly)
+}
 
-	for _, object := range objects {
-		record := []string{
-			object.ObjectKey,
-			strconv.FormatInt(object.Size, 10),
-			object.ContentType,
-			object.LastModified.Format(time.RFC3339),
-		}
-		writer.Write(record)
+// commitObject moves a fully written temporary file into place and records
+// object as the current version of objectKey. When object carries a version
+// ID the version it replaces is kept in the version history. With
+// createOnly set it returns ErrObjectExists rather than replace a current
+// version. The caller must hold the bucket lock.
+func (b *FileBackend) commitObject(bucketName, objectKey, tmpPath string, object *structure.Object, createOnly bool) error {
+	entry := &intent{
+		Op:      opPutObject,
+		Bucket:  bucketName,
+		Key:     objectKey,
+		Object:  object,
+		TmpPath: b.relative(tmpPath),
+	}
+
+	err := b.db.View(func(tx *kv.Tx) error {
+		return b.planReplace(tx, entry, object.VersionID)
+	})
+	if err != nil {
+		return err
+	}
+	if createOnly && entry.Replaced != nil {
+		return ErrObjectExists
 	}
 
-	return nil
+	return b.execute(entry)
 }
 
-func addObjectToCSV(dataDir, bucketName string, object structure.Object) error {
-	csvPath := filepath.Join(dataDir, bucketName, objectsCSV)
-
-	needHeader := false
-	_, err := os.Stat(csvPath)
+// writeTempFile streams data into a new temporary file in dir and records
+// the resulting size and MD5 ETag on object.
+func writeTempFile(dir string, data io.Reader, object *structure.Object) (string, error) {
+	tmp, err := createObjectTemp(dir)
 	if err != nil {
-		if os.IsNotExist(err) {
-			needHeader = true
-		} else {
-			return err
-		}
+		return "", err
 	}
 
-	file, err := os.OpenFile(csvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
-		return err
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), data)
+	if err == nil {
+		err = tmp.Sync()
 	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	if needHeader {
-		writer.Write([]string{"ObjectKey", "Size", "ContentType", "LastModified"})
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
 	}
 
-	record := []string{
-		object.ObjectKey,
-		strconv.FormatInt(object.Size, 10),
-		object.ContentType,
-		object.LastModified.Format(time.RFC3339),
+	err = tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
 	}
 
-	return writer.Write(record)
+	object.Size = size
+	object.ETag = hex.EncodeToString(hash.Sum(nil))
+	return tmp.Name(), nil
 }
 
-func ObjectExists(dataDir, bucketName, objectKey string) (bool, error) {
-	objectPath := filepath.Join(dataDir, bucketName, objectKey)
-	_, err := os.Stat(objectPath)
+// createObjectTemp creates the temporary file an object is written to
+// before it is renamed into place. CreateTemp makes the file private, but
+// objects are stored with the same 0644 mode as every other file.
+func createObjectTemp(dir string) (*os.File, error) {
+	tmp, err := os.CreateTemp(dir, ".upload-*")
 	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
-		} else {
-			return false, err
-		}
+		return nil, err
 	}
-	return true, nil
-}
-
-func GetObject(dataDir, bucketName, objectKey string) ([]byte, error) {
-	objectPath := filepath.Join(dataDir, bucketName, objectKey)
-	return os.ReadFile(objectPath)
-}
 
-func GetObjectMetadata(dataDir, bucketName, objectKey string) (*structure.Object, error) {
-	objects, err := listObjects(dataDir, bucketName)
+	err = tmp.Chmod(0o644)
 	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
 		return nil, err
 	}
+	return tmp, nil
+}
 
-	for _, object := range objects {
-		if object.ObjectKey == objectKey {
-			return &object, nil
-		}
+func (b *FileBackend) ObjectExists(bucketName, objectKey string) (bool, error) {
+	_, err := b.GetObjectMetadata(bucketName, objectKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
 	}
-
-	return nil, errors.New("object not found")
+	return err == nil, err
 }
 
-func listObjects(dataDir, bucketName string) ([]structure.Object, error) {
-	csvPath := filepath.Join(dataDir, bucketName, objectsCSV)
+func (b *FileBackend) GetObject(bucketName, objectKey string) (*structure.Object, io.ReadSeekCloser, error) {
+	unlock, err := b.lock(false, bucketName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
 
-	_, err := os.Stat(csvPath)
+	var object *structure.Object
+	err = b.db.View(func(tx *kv.Tx) error {
+		object, err = getObject(tx, bucketName, objectKey)
+		return err
+	})
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []structure.Object{}, nil
-		} else {
-			return nil, err
-		}
+		return nil, nil, err
+	}
+	if object == nil {
+		return nil, nil, ErrObjectNotFound
 	}
 
-	file, err := os.Open(csvPath)
+	// An open file keeps its data even once an overwrite renames another
+	// file over the path or moves it into the version history.
+	data, err := os.Open(b.keyPath(bucketName, objectKey))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer file.Close()
+	return object, data, nil
+}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+func (b *FileBackend) GetObjectMetadata(bucketName, objectKey string) (*structure.Object, error) {
+	unlock, err := b.lock(false, bucketName)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
 
-	objects := []structure.Object{}
-	for i, record := range records {
-		if i == 0 && len(record) > 0 && record[0] == "ObjectKey" {
-			continue
-		}
+	var object *structure.Object
+	err = b.db.View(func(tx *kv.Tx) error {
+		object, err = getObject(tx, bucketName, objectKey)
+		return err
+	})
+	if err == nil && object == nil {
+		err = ErrObjectNotFound
+	}
+	return object, err
+}
 
-		if len(record) < 4 {
-			log.Printf("Not enough fields in line %d: expected 4, got %d", i+1, len(record))
-			continue
-		}
+func (b *FileBackend) UpdateObject(bucketName, objectKey string, update func(*structure.Object) error) error {
+	unlock, err := b.lock(true, bucketName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-		size, err := strconv.ParseInt(record[1], 10, 64)
+	return b.db.Update(func(tx *kv.Tx) error {
+		existing, err := getObject(tx, bucketName, objectKey)
 		if err != nil {
-			log.Printf("Failed to parse Size in line %d: %v", i+1, err)
-			continue
+			return err
 		}
-		lastModified, err := time.Parse(time.RFC3339, record[3])
-		if err != nil {
-			log.Printf("Failed to parse LastModified in line %d: %v", i+1, err)
-			continue
+		if existing == nil {
+			return ErrObjectNotFound
 		}
 
-		object := structure.Object{
-			ObjectKey:    record[0],
-			Size:         size,
-			ContentType:  record[2],
-			LastModified: lastModified,
+		object, err := updatedObject(*existing, update)
+		if err != nil {
+			return err
 		}
-		objects = append(objects, object)
+		return putObject(tx, bucketName, object)
+	})
+}
+
+// updatedObject applies update to a copy of existing and restores the
+// attributes that describe the stored data.
+func updatedObject(existing structure.Object, update func(*structure.Object) error) (structure.Object, error) {
+	object := existing
+	err := update(&object)
+	if err != nil {
+		return structure.Object{}, err
 	}
 
-	return objects, nil
+	object.ObjectKey = existing.ObjectKey
+	object.Size = existing.Size
+	object.ETag = existing.ETag
+	object.VersionID = existing.VersionID
+	object.DeleteMarker = existing.DeleteMarker
+	return object, nil
 }
 
-func DeleteObject(dataDir, bucketName, objectKey string) error {
-	objectPath := filepath.Join(dataDir, bucketName, objectKey)
-
-	err := os.Remove(objectPath)
+func (b *FileBackend) ListObjects(bucketName string) ([]structure.Object, error) {
+	unlock, err := b.lock(false, bucketName)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer unlock()
 
-	return removeObjectFromCSV(dataDir, bucketName, objectKey)
+	var objects []structure.Object
+	err = b.db.View(func(tx *kv.Tx) error {
+		objects, err = listObjects(tx, bucketName)
+		return err
+	})
+	return objects, err
 }
 
-func removeObjectFromCSV(dataDir, bucketName, objectKey string) error {
-	csvPath := filepath.Join(dataDir, bucketName, objectsCSV)
-
-	objects, err := listObjects(dataDir, bucketName)
+func (b *FileBackend) WalkObjects(bucketName, prefix, startAfter string, fn func(structure.Object) bool) error {
+	unlock, err := b.lock(false, bucketName)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	filteredObjects := []structure.Object{}
-	for _, object := range objects {
-		if object.ObjectKey != objectKey {
-			filteredObjects = append(filteredObjects, object)
+	return b.db.View(func(tx *kv.Tx) error {
+		_, err := getBucket(tx, bucketName)
+		if err != nil {
+			return err
 		}
-	}
 
-	file, err := os.Create(csvPath)
+		keyPrefix := objectsPrefix(bucketName)
+		start := keyPrefix + startAfter
+		tx.ScanFrom(keyPrefix+prefix, start, func(key string, value []byte) bool {
+			if key == start {
+				return true
+			}
+			var object *structure.Object
+			object, err = decodeObject(key, value)
+			if err != nil {
+				return false
+			}
+			return fn(*object)
+		})
+		return err
+	})
+}
+
+func (b *FileBackend) DeleteObject(bucketName, objectKey string) error {
+	unlock, err := b.lock(true, bucketName)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer unlock()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	writer.Write([]string{"ObjectKey", "Size", "ContentType", "LastModified"})
+	failed, err := b.deleteObjects(bucketName, []string{objectKey})
+	if err != nil {
+		return err
+	}
+	return failed[objectKey]
+}
 
-	for _, object := range filteredObjects {
-		record := []string{
-			object.ObjectKey,
-			strconv.FormatInt(object.Size, 10),
-			object.ContentType,
-			object.LastModified.Format(time.RFC3339),
+func removeEmptyParents(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if os.Remove(dir) != nil {
+			return
 		}
-		writer.Write(record)
+		dir = filepath.Dir(dir)
 	}
-
-	return nil
 }
@@ -0,0 +1,694 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"triple-s/internal/structure"
+)
+
+func newTestFileBackend(t *testing.T) *FileBackend {
+	t.Helper()
+
+	b, err := NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.db.Close() })
+	return b
+}
+
+// TestFileBackendCreateBucketRace creates the same bucket from many
+// goroutines at once and checks that exactly one of them succeeds.
+func TestFileBackendCreateBucketRace(t *testing.T) {
+	const workers = 50
+
+	b := newTestFileBackend(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for worker := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- b.CreateBucket(structure.Bucket{Name: "race-bucket", Owner: fmt.Sprint(worker)})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrBucketExists):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("bucket created %d times, want once", created)
+	}
+}
+
+// TestFileBackendUpdateBucketRace changes different attributes of a bucket
+// from many goroutines at once and checks that none of the changes is lost.
+func TestFileBackendUpdateBucketRace(t *testing.T) {
+	const rounds = 50
+
+	b := newTestFileBackend(t)
+	err := b.CreateBucket(structure.Bucket{Name: "race-bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setters := []func(*structure.Bucket, string){
+		func(bucket *structure.Bucket, value string) { bucket.Versioning = value },
+		func(bucket *structure.Bucket, value string) { bucket.Policy = value },
+		func(bucket *structure.Bucket, value string) { bucket.ACL = value },
+		func(bucket *structure.Bucket, value string) { bucket.Lifecycle = value },
+	}
+
+	var wg sync.WaitGroup
+	for _, set := range setters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				err := b.UpdateBucket("race-bucket", func(bucket *structure.Bucket) error {
+					set(bucket, fmt.Sprint(round))
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	bucket, err := b.GetBucket("race-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprint(rounds - 1)
+	for name, got := range map[string]string{
+		"Versioning": bucket.Versioning,
+		"Policy":     bucket.Policy,
+		"ACL":        bucket.ACL,
+		"Lifecycle":  bucket.Lifecycle,
+	} {
+		if got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestFileBackendUpdateObjectDuringOverwrite sets the ACL of a key while
+// other goroutines overwrite it and checks that the record still describes
+// the data afterwards.
+func TestFileBackendUpdateObjectDuringOverwrite(t *testing.T) {
+	const rounds = 100
+
+	b := newTestFileBackend(t)
+	err := b.CreateBucket(structure.Bucket{Name: "race-bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.StoreObject("race-bucket", "key", strings.NewReader("first"), &structure.Object{ObjectKey: "key"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for round := range rounds {
+			object := &structure.Object{ObjectKey: "key", VersionID: fmt.Sprint("v", round)}
+			err := b.StoreObject("race-bucket", "key", strings.NewReader(strings.Repeat("x", round)), object, false)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range rounds {
+			err := b.UpdateObject("race-bucket", "key", func(object *structure.Object) error {
+				object.ACL = "public-read"
+				object.Size = -1
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	object, err := b.GetObjectMetadata("race-bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Size != rounds-1 || object.VersionID != fmt.Sprint("v", rounds-1) {
+		t.Errorf("record has size %d and version %s, want %d and v%d", object.Size, object.VersionID, rounds-1, rounds-1)
+	}
+	checkObjectData(t, b, "race-bucket", "key", strings.Repeat("x", rounds-1))
+
+	versions, err := b.ListObjectVersions("race-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, version := range versions {
+		if seen[version.VersionID] {
+			t.Errorf("version %s listed twice", version.VersionID)
+		}
+		seen[version.VersionID] = true
+	}
+}
+
+// TestFileBackendDeleteBucketRace deletes a bucket while objects are stored
+// in it and checks that a bucket is never deleted along with an object
+// that was reported stored.
+func TestFileBackendDeleteBucketRace(t *testing.T) {
+	const (
+		trials  = 20
+		writers = 10
+	)
+
+	b := newTestFileBackend(t)
+	for trial := range trials {
+		bucketName := fmt.Sprintf("race-bucket-%d", trial)
+		err := b.CreateBucket(structure.Bucket{Name: bucketName})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var stored sync.Map
+		for writer := range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				key := fmt.Sprint("key", writer)
+				err := b.StoreObject(bucketName, key, strings.NewReader(key), &structure.Object{ObjectKey: key}, false)
+				if err == nil {
+					stored.Store(key, true)
+				}
+			}()
+		}
+		err = b.DeleteBucket(bucketName)
+		wg.Wait()
+
+		switch {
+		case err == nil:
+			stored.Range(func(key, _ any) bool {
+				t.Errorf("%s: bucket deleted although %s was stored", bucketName, key)
+				return true
+			})
+		case !errors.Is(err, ErrBucketNotEmpty):
+			t.Errorf("%s: unexpected error: %v", bucketName, err)
+		}
+	}
+}
+
+// TestFileBackendCreateOnlyRace stores the same key with createOnly set
+// from many goroutines at once and checks that exactly one of them wins.
+func TestFileBackendCreateOnlyRace(t *testing.T) {
+	const workers = 50
+
+	b := newTestFileBackend(t)
+	err := b.CreateBucket(structure.Bucket{Name: "race-bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for worker := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data := strings.NewReader(fmt.Sprint(worker))
+			errs <- b.StoreObject("race-bucket", "key", data, &structure.Object{ObjectKey: "key"}, true)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	stored := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			stored++
+		case !errors.Is(err, ErrObjectExists):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if stored != 1 {
+		t.Errorf("object stored %d times, want once", stored)
+	}
+}
+
+// TestFileBackendGetObjectDuringOverwrite reads a key while other
+// goroutines overwrite it, with and without versioning, and checks that the
+// data read always matches the metadata returned with it.
+func TestFileBackendGetObjectDuringOverwrite(t *testing.T) {
+	const (
+		writers = 20
+		readers = 20
+		rounds  = 20
+	)
+
+	for _, versioned := range []bool{false, true} {
+		t.Run(fmt.Sprintf("versioned=%v", versioned), func(t *testing.T) {
+			b := newTestFileBackend(t)
+			err := b.CreateBucket(structure.Bucket{Name: "race-bucket"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = b.StoreObject("race-bucket", "key", strings.NewReader("first"), &structure.Object{ObjectKey: "key"}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			for writer := range writers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for round := range rounds {
+						object := &structure.Object{ObjectKey: "key"}
+						if versioned {
+							object.VersionID = fmt.Sprintf("v%d-%d", writer, round)
+						}
+						data := strings.Repeat("x", writer*rounds+round)
+						err := b.StoreObject("race-bucket", "key", strings.NewReader(data), object, false)
+						if err != nil {
+							t.Error(err)
+						}
+					}
+				}()
+			}
+			for range readers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range rounds {
+						object, data, err := b.GetObject("race-bucket", "key")
+						if err != nil {
+							t.Error(err)
+							continue
+						}
+						content, err := io.ReadAll(data)
+						data.Close()
+						if err != nil {
+							t.Error(err)
+							continue
+						}
+						sum := md5.Sum(content)
+						if int64(len(content)) != object.Size || hex.EncodeToString(sum[:]) != object.ETag {
+							t.Errorf("read %d bytes with ETag %x, metadata says %d bytes with ETag %s",
+								len(content), sum, object.Size, object.ETag)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// TestFileBackendConcurrentObjects stores, deletes and lists the same keys
+// of one bucket from many goroutines at once and checks that the metadata
+// and the data directory agree afterwards.
+func TestFileBackendConcurrentObjects(t *testing.T) {
+	const (
+		workers = 300
+		rounds  = 10
+		keys    = 16
+	)
+
+	b := newTestFileBackend(t)
+	err := b.CreateBucket(structure.Bucket{Name: "race-bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds)
+	for worker := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				key := fmt.Sprintf("k%d", (worker+round)%keys)
+				if worker%2 == 1 {
+					key = "dir/sub/" + key
+				}
+
+				var err error
+				switch (worker + round) % 3 {
+				case 0:
+					data := strings.Repeat(fmt.Sprintf("%d/%d ", worker, round), worker%7+1)
+					err = b.StoreObject("race-bucket", key, strings.NewReader(data), &structure.Object{ObjectKey: key}, false)
+				case 1:
+					err = b.DeleteObject("race-bucket", key)
+				case 2:
+					_, err = b.ListObjects("race-bucket")
+				}
+				if err != nil {
+					errs <- fmt.Errorf("worker %d round %d on %s: %w", worker, round, key, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	objects, err := b.ListObjects("race-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := make(map[string]bool)
+	for _, object := range objects {
+		recorded[object.ObjectKey] = true
+
+		data, err := os.ReadFile(b.keyPath("race-bucket", object.ObjectKey))
+		if err != nil {
+			t.Errorf("%s: recorded but its data cannot be read: %v", object.ObjectKey, err)
+			continue
+		}
+		sum := md5.Sum(data)
+		if int64(len(data)) != object.Size || hex.EncodeToString(sum[:]) != object.ETag {
+			t.Errorf("%s: data does not match the record: %d bytes with ETag %x, recorded %d bytes with ETag %s",
+				object.ObjectKey, len(data), sum, object.Size, object.ETag)
+		}
+	}
+
+	bucketDir := filepath.Join(b.dataDir, "race-bucket")
+	err = filepath.WalkDir(bucketDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || path == bucketDir || entry.IsDir() {
+			return err
+		}
+
+		relative, err := filepath.Rel(bucketDir, path)
+		if err != nil {
+			return err
+		}
+		key, ok := decodeKey(relative)
+		if !ok {
+			t.Errorf("%s: left behind in the bucket directory", relative)
+		} else if !recorded[key] {
+			t.Errorf("%s: stored on disk but not recorded", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFileBackendKeyPrefixes stores keys that are each other's prefixes,
+// in both orders, and checks that every one keeps its own data.
+func TestFileBackendKeyPrefixes(t *testing.T) {
+	b := newTestFileBackend(t)
+	err := b.CreateBucket(structure.Bucket{Name: "prefix-bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"a", "a/b", "c/d", "c", "e/", "e", "e//"}
+	for _, key := range keys {
+		err := b.StoreObject("prefix-bucket", key, strings.NewReader("data of "+key), &structure.Object{ObjectKey: key}, false)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+	}
+	for _, key := range keys {
+		checkObjectData(t, b, "prefix-bucket", key, "data of "+key)
+	}
+
+	for _, key := range []string{"a", "c/d"} {
+		err = b.DeleteObject("prefix-bucket", key)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+	}
+	checkObjectData(t, b, "prefix-bucket", "a/b", "data of a/b")
+	checkObjectData(t, b, "prefix-bucket", "c", "data of c")
+}
+
+// TestFileBackendLongSegments stores keys whose segments are longer than a
+// file name can be, before and after escaping.
+func TestFileBackendLongSegments(t *testing.T) {
+	b := newTestFileBackend(t)
+	err := b.CreateBucket(structure.Bucket{Name: "long-bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{
+		strings.Repeat("k", 300),
+		strings.Repeat("k", 250),
+		strings.Repeat("%", 300),
+		"dir/" + strings.Repeat("é", 400) + "/" + strings.Repeat(".", 200),
+	}
+	for _, key := range keys {
+		err := b.StoreObject("long-bucket", key, strings.NewReader("data of "+key), &structure.Object{ObjectKey: key}, false)
+		if err != nil {
+			t.Fatalf("%.20s...: %v", key, err)
+		}
+	}
+	for _, key := range keys {
+		checkObjectData(t, b, "long-bucket", key, "data of "+key)
+	}
+}
+
+// TestFileBackendExpireObject checks that ExpireObject leaves alone an
+// object overwritten since it was observed, and removes or hides one that
+// was not.
+func TestFileBackendExpireObject(t *testing.T) {
+	for _, versioned := range []bool{false, true} {
+		t.Run(fmt.Sprintf("versioned %v", versioned), func(t *testing.T) {
+			b := newTestFileBackend(t)
+			err := b.CreateBucket(structure.Bucket{Name: "expire-bucket"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			versionID := ""
+			if versioned {
+				versionID, err = NewVersionID()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			observed := storeTestObject(t, b, "expire-bucket", "key", "old data")
+			storeTestObject(t, b, "expire-bucket", "key", "new data")
+			err = b.ExpireObject("expire-bucket", *observed, versionID)
+			if !errors.Is(err, ErrObjectChanged) {
+				t.Errorf("expired an overwritten object: %v", err)
+			}
+			checkObjectData(t, b, "expire-bucket", "key", "new data")
+
+			observed, data, err := b.GetObject("expire-bucket", "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data.Close()
+			err = b.ExpireObject("expire-bucket", *observed, versionID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = b.GetObject("expire-bucket", "key")
+			if !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("object still readable after expiry: %v", err)
+			}
+		})
+	}
+}
+
+// TestFileBackendCopyChecked checks that a copy of a source that was
+// overwritten since it was checked fails and leaves no destination.
+func TestFileBackendCopyChecked(t *testing.T) {
+	b := newTestFileBackend(t)
+	err := b.CreateBucket(structure.Bucket{Name: "copy-bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checked := storeTestObject(t, b, "copy-bucket", "source", "old data")
+	storeTestObject(t, b, "copy-bucket", "source", "new data")
+	err = b.CopyObject("copy-bucket", "source", "", checked, "copy-bucket", "copy", &structure.Object{ObjectKey: "copy"})
+	if !errors.Is(err, ErrObjectChanged) {
+		t.Errorf("copied an overwritten source: %v", err)
+	}
+	_, _, err = b.GetObject("copy-bucket", "copy")
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("copy exists after a failed copy: %v", err)
+	}
+
+	checked, data, err := b.GetObject("copy-bucket", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	err = b.CopyObject("copy-bucket", "source", "", checked, "copy-bucket", "copy", &structure.Object{ObjectKey: "copy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkObjectData(t, b, "copy-bucket", "copy", "new data")
+}
+
+// TestFileBackendDeleteObjectsRecovery interrupts DeleteObjects before and
+// after its files are moved aside and checks that reopening the data
+// directory leaves the objects and their records in agreement.
+func TestFileBackendDeleteObjectsRecovery(t *testing.T) {
+	for _, prepared := range []bool{false, true} {
+		t.Run(fmt.Sprintf("prepared %v", prepared), func(t *testing.T) {
+			dataDir := t.TempDir()
+			b, err := NewFileBackend(dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = b.CreateBucket(structure.Bucket{Name: "delete-bucket"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := []string{"a", "a/b"}
+			for _, key := range keys {
+				storeTestObject(t, b, "delete-bucket", key, "data of "+key)
+			}
+
+			entry := &intent{Op: opDeleteObjects, Bucket: "delete-bucket", Keys: keys, failed: make(map[string]error)}
+			err = b.begin(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if prepared {
+				err = b.prepare(entry)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			b.db.Close()
+
+			b, err = NewFileBackend(dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.db.Close()
+			for _, key := range keys {
+				if prepared {
+					_, _, err := b.GetObject("delete-bucket", key)
+					if !errors.Is(err, ErrObjectNotFound) {
+						t.Errorf("%s: %v, want %v", key, err, ErrObjectNotFound)
+					}
+				} else {
+					checkObjectData(t, b, "delete-bucket", key, "data of "+key)
+				}
+			}
+
+			entries, err := os.ReadDir(filepath.Join(dataDir, "delete-bucket"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if prepared && len(entries) > 0 {
+				t.Errorf("bucket directory still holds %s", entries[0].Name())
+			}
+		})
+	}
+}
+
+// TestFileBackendWalkObjects checks that WalkObjects starts after
+// startAfter, keeps to the prefix and stops when asked to.
+func TestFileBackendWalkObjects(t *testing.T) {
+	b := newTestFileBackend(t)
+	err := b.CreateBucket(structure.Bucket{Name: "walk-bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b/1", "b/2", "b/3", "b0", "c"} {
+		storeTestObject(t, b, "walk-bucket", key, "data of "+key)
+	}
+
+	tests := []struct {
+		prefix, startAfter string
+		limit              int
+		want               []string
+	}{
+		{"", "", 10, []string{"a", "b/1", "b/2", "b/3", "b0", "c"}},
+		{"b/", "", 10, []string{"b/1", "b/2", "b/3"}},
+		{"b/", "b/1", 10, []string{"b/2", "b/3"}},
+		{"b/", "a", 10, []string{"b/1", "b/2", "b/3"}},
+		{"b", "b/2", 2, []string{"b/3", "b0"}},
+		{"", "b/", 1, []string{"b/1"}},
+		{"", "c", 10, nil},
+	}
+	for _, test := range tests {
+		var got []string
+		err := b.WalkObjects("walk-bucket", test.prefix, test.startAfter, func(object structure.Object) bool {
+			got = append(got, object.ObjectKey)
+			return len(got) < test.limit
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("WalkObjects(%q, %q) = %v, want %v", test.prefix, test.startAfter, got, test.want)
+		}
+	}
+
+	err = b.WalkObjects("no-bucket", "", "", func(structure.Object) bool { return true })
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("walked a missing bucket: %v", err)
+	}
+}
+
+func storeTestObject(t *testing.T, b *FileBackend, bucketName, objectKey, content string) *structure.Object {
+	t.Helper()
+
+	err := b.StoreObject(bucketName, objectKey, strings.NewReader(content), &structure.Object{ObjectKey: objectKey}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, data, err := b.GetObject(bucketName, objectKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Close()
+	return object
+}
+
+func checkObjectData(t *testing.T, b *FileBackend, bucketName, objectKey, want string) {
+	t.Helper()
+
+	_, data, err := b.GetObject(bucketName, objectKey)
+	if err != nil {
+		t.Errorf("%.20s: %v", objectKey, err)
+		return
+	}
+	defer data.Close()
+
+	content, err := io.ReadAll(data)
+	if err != nil {
+		t.Errorf("%.20s: %v", objectKey, err)
+	} else if string(content) != want {
+		t.Errorf("%.20s: read %.30q, want %.30q", objectKey, content, want)
+	}
+}
@@ -0,0 +1,391 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"triple-s/internal/kv"
+	"triple-s/internal/structure"
+)
+
+const (
+	versionsDir = ".versions"
+
+	// NullVersionID identifies the version written while versioning is
+	// suspended, or before it was ever enabled.
+	NullVersionID = "null"
+)
+
+// Versioning states of a bucket. A bucket that never had versioning turned
+// on has an empty state.
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+)
+
+// NewVersionID returns a fresh version ID for a bucket with versioning
+// enabled.
+func NewVersionID() (string, error) {
+	return newUploadID()
+}
+
+// VersionIDFor returns the version ID for an object written to bucket now:
+// a fresh ID when versioning is enabled, the null version when it is
+// suspended, and no version at all when it was never turned on.
+func VersionIDFor(bucket *structure.Bucket) (string, error) {
+	switch bucket.Versioning {
+	case VersioningEnabled:
+		return NewVersionID()
+	case VersioningSuspended:
+		return NullVersionID, nil
+	}
+	return "", nil
+}
+
+func isNullVersion(versionID string) bool {
+	return versionID == "" || versionID == NullVersionID
+}
+
+func sameVersion(a, b string) bool {
+	if isNullVersion(a) {
+		return isNullVersion(b)
+	}
+	return a == b
+}
+
+// reportedVersion is the version ID shown to clients, which call objects
+// written without versioning "null".
+func reportedVersion(versionID string) string {
+	if versionID == "" {
+		return NullVersionID
+	}
+	return versionID
+}
+
+// versionPath is where the data of a noncurrent version is kept. Keys are
+// hashed so that arbitrarily long or nested keys map to a single directory.
+func (b *FileBackend) versionPath(bucketName, objectKey, versionID string) string {
+	sum := sha256.Sum256([]byte(objectKey))
+	return filepath.Join(b.dataDir, bucketName, versionsDir, hex.EncodeToString(sum[:]), reportedVersion(versionID))
+}
+
+// planReplace fills in how entry makes room for a new version of its key.
+// The current version, if any, is moved into the version history unless
+// both it and the new version are the null version, in which case it is
+// simply replaced. A new null version also replaces any null version
+// already in the history.
+func (b *FileBackend) planReplace(tx *kv.Tx, entry *intent, newVersionID string) error {
+	current, err := getObject(tx, entry.Bucket, entry.Key)
+	if err != nil {
+		return err
+	}
+	entry.Replaced = current
+	if newVersionID == "" {
+		return nil
+	}
+
+	if isNullVersion(newVersionID) {
+		history, err := keyHistory(tx, entry.Bucket, entry.Key)
+		if err != nil {
+			return err
+		}
+		for _, version := range history {
+			if !isNullVersion(version.version.VersionID) {
+				continue
+			}
+			entry.Dropped = append(entry.Dropped, version.key)
+			if !version.version.DeleteMarker {
+				entry.Garbage = append(entry.Garbage, b.relative(b.versionPath(entry.Bucket, entry.Key, version.version.VersionID)))
+			}
+		}
+	}
+
+	entry.Archive = current != nil && !(isNullVersion(current.VersionID) && isNullVersion(newVersionID))
+	return nil
+}
+
+func (b *FileBackend) PutDeleteMarker(bucketName, objectKey, versionID string) error {
+	unlock, err := b.lock(true, bucketName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return b.putDeleteMarker(bucketName, objectKey, versionID)
+}
+
+// putDeleteMarker makes a new delete marker the current version of
+// objectKey. The caller must hold the bucket lock.
+func (b *FileBackend) putDeleteMarker(bucketName, objectKey, versionID string) error {
+	entry := &intent{
+		Op:     opPutDeleteMarker,
+		Bucket: bucketName,
+		Key:    objectKey,
+		Object: &structure.Object{
+			ObjectKey:    objectKey,
+			LastModified: time.Now(),
+			VersionID:    reportedVersion(versionID),
+			DeleteMarker: true,
+		},
+	}
+
+	err := b.db.View(func(tx *kv.Tx) error {
+		return b.planReplace(tx, entry, versionID)
+	})
+	if err != nil {
+		return err
+	}
+	if entry.Replaced != nil && !entry.Archive {
+		entry.Garbage = append(entry.Garbage, b.relative(b.objectPath(entry)))
+	}
+
+	return b.execute(entry)
+}
+
+func (b *FileBackend) ExpireObject(bucketName string, observed structure.Object, versionID string) error {
+	unlock, err := b.lock(true, bucketName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = b.db.View(func(tx *kv.Tx) error {
+		current, err := getObject(tx, bucketName, observed.ObjectKey)
+		if err != nil {
+			return err
+		}
+		if current == nil || !sameObject(*current, observed) {
+			return ErrObjectChanged
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if versionID != "" {
+		return b.putDeleteMarker(bucketName, observed.ObjectKey, versionID)
+	}
+	failed, err := b.deleteObjects(bucketName, []string{observed.ObjectKey})
+	if err != nil {
+		return err
+	}
+	return failed[observed.ObjectKey]
+}
+
+// sameObject reports whether two records describe the same version of a
+// key.
+func sameObject(a, b structure.Object) bool {
+	return a.ETag == b.ETag && a.VersionID == b.VersionID && a.LastModified.Equal(b.LastModified)
+}
+
+func (b *FileBackend) DeleteObjectVersion(bucketName, objectKey, versionID string) (*structure.Object, error) {
+	unlock, err := b.lock(true, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entry := &intent{
+		Op:     opDeleteVersion,
+		Bucket: bucketName,
+		Key:    objectKey,
+	}
+
+	var removed *structure.Object
+	err = b.db.View(func(tx *kv.Tx) error {
+		removed, err = b.planDeleteVersion(tx, entry, versionID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.execute(entry)
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// planDeleteVersion fills in how entry deletes versionID of its key and
+// returns that version. When the version removed is the latest one, the
+// newest remaining version becomes current again unless it is a delete
+// marker.
+func (b *FileBackend) planDeleteVersion(tx *kv.Tx, entry *intent, versionID string) (*structure.Object, error) {
+	current, err := getObject(tx, entry.Bucket, entry.Key)
+	if err != nil {
+		return nil, err
+	}
+	history, err := keyHistory(tx, entry.Bucket, entry.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed *structure.Object
+	var promote *historyEntry
+	switch {
+	case current != nil && sameVersion(current.VersionID, versionID):
+		removed = current
+		entry.Removed = objectsPrefix(entry.Bucket) + entry.Key
+		if len(history) > 0 {
+			promote = &history[len(history)-1]
+		}
+
+	default:
+		found := -1
+		for i, version := range history {
+			if sameVersion(version.version.VersionID, versionID) {
+				found = i
+			}
+		}
+		if found < 0 {
+			return nil, ErrNoSuchVersion
+		}
+
+		removed = &history[found].version
+		entry.Removed = history[found].key
+		if !removed.DeleteMarker {
+			entry.Garbage = append(entry.Garbage, b.relative(b.versionPath(entry.Bucket, entry.Key, removed.VersionID)))
+		}
+
+		// Removing the delete marker that hid an object brings the
+		// previous version back.
+		if found == len(history)-1 && current == nil && found > 0 {
+			promote = &history[found-1]
+		}
+	}
+
+	if promote != nil && !promote.version.DeleteMarker {
+		entry.Object = &promote.version
+		entry.Promoted = promote.key
+	} else if entry.Removed == objectsPrefix(entry.Bucket)+entry.Key {
+		entry.Garbage = append(entry.Garbage, b.relative(b.objectPath(entry)))
+	}
+
+	return removed, nil
+}
+
+func (b *FileBackend) GetObjectVersionMetadata(bucketName, objectKey, versionID string) (*structure.Object, error) {
+	unlock, err := b.lock(false, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var version *structure.Object
+	err = b.db.View(func(tx *kv.Tx) error {
+		version, _, err = b.findVersion(tx, bucketName, objectKey, versionID)
+		return err
+	})
+	return version, err
+}
+
+func (b *FileBackend) GetObjectVersion(bucketName, objectKey, versionID string) (*structure.Object, io.ReadSeekCloser, error) {
+	unlock, err := b.lock(false, bucketName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	var version *structure.Object
+	var dataPath string
+	err = b.db.View(func(tx *kv.Tx) error {
+		version, dataPath, err = b.findVersion(tx, bucketName, objectKey, versionID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if version.DeleteMarker {
+		return version, nil, nil
+	}
+
+	data, err := os.Open(dataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return version, data, nil
+}
+
+// findVersion looks versionID of objectKey up among the current objects and
+// the version history, and returns it along with the path of its data.
+func (b *FileBackend) findVersion(tx *kv.Tx, bucketName, objectKey, versionID string) (*structure.Object, string, error) {
+	current, err := getObject(tx, bucketName, objectKey)
+	if err != nil {
+		return nil, "", err
+	}
+	if current != nil && sameVersion(current.VersionID, versionID) {
+		current.IsLatest = true
+		return current, b.keyPath(bucketName, objectKey), nil
+	}
+
+	history, err := keyHistory(tx, bucketName, objectKey)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, entry := range history {
+		if sameVersion(entry.version.VersionID, versionID) {
+			return &entry.version, b.versionPath(bucketName, objectKey, entry.version.VersionID), nil
+		}
+	}
+
+	return nil, "", ErrNoSuchVersion
+}
+
+func (b *FileBackend) ListObjectVersions(bucketName string) ([]structure.Object, error) {
+	unlock, err := b.lock(false, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var objects, history []structure.Object
+	err = b.db.View(func(tx *kv.Tx) error {
+		objects, err = listObjects(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		history, err = listHistory(tx, bucketName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sortVersions(objects, history), nil
+}
+
+// sortVersions merges the current objects with their history, given from
+// oldest to newest, ordered by key and then from newest to oldest, and
+// marks the latest version of each key.
+func sortVersions(objects, history []structure.Object) []structure.Object {
+	byKey := make(map[string][]structure.Object)
+	for _, object := range objects {
+		object.VersionID = reportedVersion(object.VersionID)
+		byKey[object.ObjectKey] = append(byKey[object.ObjectKey], object)
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		byKey[history[i].ObjectKey] = append(byKey[history[i].ObjectKey], history[i])
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	versions := make([]structure.Object, 0, len(objects)+len(history))
+	for _, key := range keys {
+		for i, version := range byKey[key] {
+			version.IsLatest = i == 0
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
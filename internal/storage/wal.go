@@ -0,0 +1,457 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"triple-s/internal/kv"
+	"triple-s/internal/structure"
+)
+
+// Operations that change both files and metadata are carried out in three
+// steps so that a crash between them cannot leave the two disagreeing:
+//
+//  1. An intent describing the whole operation is written to the
+//     write-ahead log.
+//  2. The file changes that must precede the metadata are made: renames
+//     and new directories only, which can be undone or detected
+//     afterwards. Data that is going away is moved aside, not removed.
+//  3. The metadata is updated and the intent marked committed in a single
+//     transaction.
+//
+// Files that are no longer referenced are removed after the commit and the
+// intent is then deleted. When the data directory is opened, intents left
+// by a crash are replayed: committed ones have their files removed again,
+// and pending ones are rolled forward if all of step 2 happened and back
+// otherwise.
+//
+// The log lives in the metadata database, under walPrefix, so that
+// committing an intent is atomic with the metadata change itself.
+const walPrefix = "wal/"
+
+const (
+	opCreateBucket    = "CreateBucket"
+	opDeleteBucket    = "DeleteBucket"
+	opDeleteObjects   = "DeleteObjects"
+	opPutObject       = "PutObject"
+	opPutDeleteMarker = "PutDeleteMarker"
+	opDeleteVersion   = "DeleteObjectVersion"
+)
+
+// intent is a write-ahead log entry. Paths are relative to the data
+// directory so that the directory can be moved between a crash and the
+// replay.
+type intent struct {
+	Op     string `json:"op"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key,omitempty"`
+
+	// Keys are the objects removed by DeleteObjects.
+	Keys []string `json:"keys,omitempty"`
+
+	// Record is the bucket added by CreateBucket.
+	Record *structure.Bucket `json:"record,omitempty"`
+
+	// Object is the object written by PutObject, the delete marker added
+	// by PutDeleteMarker, or the version DeleteObjectVersion makes current
+	// again.
+	Object *structure.Object `json:"object,omitempty"`
+
+	// Replaced is the current object that PutObject and PutDeleteMarker
+	// replace, moved into the version history when Archive is set.
+	Replaced *structure.Object `json:"replaced,omitempty"`
+	Archive  bool              `json:"archive,omitempty"`
+
+	// TmpPath is the file PutObject moves into place, or the name a bucket
+	// directory is moved to by DeleteBucket before it is removed.
+	TmpPath string `json:"tmpPath,omitempty"`
+
+	// Removed is the database key of the version DeleteObjectVersion
+	// deletes and Promoted that of the version it makes current.
+	Removed  string `json:"removed,omitempty"`
+	Promoted string `json:"promoted,omitempty"`
+
+	// Dropped are the database keys of history records deleted on commit,
+	// and Garbage the files that become unreferenced.
+	Dropped []string `json:"dropped,omitempty"`
+	Garbage []string `json:"garbage,omitempty"`
+
+	Committed bool `json:"committed,omitempty"`
+
+	key    string
+	failed map[string]error
+}
+
+// execute runs entry through the log. If the file changes fail the
+// operation is resolved right away, which undoes them where it can.
+func (b *FileBackend) execute(entry *intent) error {
+	err := b.begin(entry)
+	if err != nil {
+		return err
+	}
+
+	err = b.prepare(entry)
+	if err != nil {
+		resolveErr := b.resolve(entry)
+		if resolveErr != nil {
+			log.Printf("storage: %s of %s left for recovery: %v", entry.Op, entry.Bucket, resolveErr)
+		}
+		return err
+	}
+
+	return b.complete(entry)
+}
+
+// begin appends entry to the log.
+func (b *FileBackend) begin(entry *intent) error {
+	return b.db.Update(func(tx *kv.Tx) error {
+		var sequence uint64
+		tx.Scan(walPrefix, func(key string, value []byte) bool {
+			last, err := strconv.ParseUint(strings.TrimPrefix(key, walPrefix), 16, 64)
+			if err == nil && last >= sequence {
+				sequence = last + 1
+			}
+			return true
+		})
+
+		entry.key = fmt.Sprintf("%s%016x", walPrefix, sequence)
+		return putIntent(tx, entry)
+	})
+}
+
+func putIntent(tx *kv.Tx, entry *intent) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return tx.Put(entry.key, value)
+}
+
+// complete commits the metadata of entry, removes its garbage and deletes
+// it from the log. An error committing leaves the intent pending.
+func (b *FileBackend) complete(entry *intent) error {
+	err := b.db.Update(func(tx *kv.Tx) error {
+		err := b.commit(tx, entry)
+		if err != nil {
+			return err
+		}
+
+		committed := *entry
+		committed.Committed = true
+		return putIntent(tx, &committed)
+	})
+	if err != nil {
+		return err
+	}
+	entry.Committed = true
+
+	b.cleanup(entry)
+	b.finish(entry)
+	return nil
+}
+
+// resolve rolls a pending intent forward if its file changes were all made,
+// and back otherwise.
+func (b *FileBackend) resolve(entry *intent) error {
+	if b.prepared(entry) {
+		return b.complete(entry)
+	}
+
+	b.undo(entry)
+	b.finish(entry)
+	return nil
+}
+
+func (b *FileBackend) finish(entry *intent) {
+	err := b.db.Update(func(tx *kv.Tx) error {
+		return tx.Delete(entry.key)
+	})
+	if err != nil {
+		log.Printf("storage: failed to remove %s of %s from the log: %v", entry.Op, entry.Bucket, err)
+	}
+}
+
+// recover replays the intents left in the log by a crash. The caller must
+// hold the data directory lock.
+func (b *FileBackend) recover() error {
+	var entries []*intent
+	err := b.db.View(func(tx *kv.Tx) error {
+		var err error
+		tx.Scan(walPrefix, func(key string, value []byte) bool {
+			entry := &intent{key: key}
+			err = json.Unmarshal(value, entry)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", key, err)
+				return false
+			}
+			entries = append(entries, entry)
+			return true
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		log.Printf("Recovering interrupted %s of %s", entry.Op, entry.Bucket)
+
+		if entry.Committed {
+			b.cleanup(entry)
+			b.finish(entry)
+			continue
+		}
+		err = b.resolve(entry)
+		if err != nil {
+			return fmt.Errorf("recovering %s of %s: %w", entry.Op, entry.Bucket, err)
+		}
+	}
+	return nil
+}
+
+func (b *FileBackend) path(relative string) string {
+	return filepath.Join(b.dataDir, relative)
+}
+
+func (b *FileBackend) relative(path string) string {
+	relative, err := filepath.Rel(b.dataDir, path)
+	if err != nil {
+		return path
+	}
+	return relative
+}
+
+func (b *FileBackend) objectPath(entry *intent) string {
+	return b.keyPath(entry.Bucket, entry.Key)
+}
+
+// prepare makes the file changes of entry that precede its commit.
+func (b *FileBackend) prepare(entry *intent) error {
+	switch entry.Op {
+	case opCreateBucket:
+		return os.MkdirAll(filepath.Join(b.dataDir, entry.Bucket), 0o755)
+
+	case opDeleteBucket:
+		err := os.Rename(filepath.Join(b.dataDir, entry.Bucket), b.path(entry.TmpPath))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+
+	case opDeleteObjects:
+		for _, objectKey := range entry.Keys {
+			objectPath := b.keyPath(entry.Bucket, objectKey)
+			err := os.Rename(objectPath, deletedPath(objectPath))
+			if err != nil && !os.IsNotExist(err) {
+				entry.failed[objectKey] = err
+			}
+		}
+		return nil
+
+	case opPutObject:
+		objectPath := b.objectPath(entry)
+		if entry.Archive {
+			err := b.archive(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := os.MkdirAll(filepath.Dir(objectPath), 0o755)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(b.path(entry.TmpPath), objectPath)
+		if err != nil {
+			return err
+		}
+		return syncDir(filepath.Dir(objectPath))
+
+	case opPutDeleteMarker:
+		if entry.Archive {
+			return b.archive(entry)
+		}
+		return nil
+
+	case opDeleteVersion:
+		if entry.Object == nil {
+			return nil
+		}
+		objectPath := b.objectPath(entry)
+		err := os.MkdirAll(filepath.Dir(objectPath), 0o755)
+		if err != nil {
+			return err
+		}
+		return os.Rename(b.versionPath(entry.Bucket, entry.Key, entry.Object.VersionID), objectPath)
+	}
+
+	return fmt.Errorf("unknown operation %q", entry.Op)
+}
+
+// deletedPath returns where DeleteObjects moves the data at objectPath
+// until the deletion is committed. It is in the directory of the key, so
+// the move is a rename within one directory and no other key uses it.
+func deletedPath(objectPath string) string {
+	return filepath.Join(filepath.Dir(objectPath), deletedFile)
+}
+
+// archive moves the data of the replaced object to its place in the
+// version history.
+func (b *FileBackend) archive(entry *intent) error {
+	archivedPath := b.versionPath(entry.Bucket, entry.Key, entry.Replaced.VersionID)
+	err := os.MkdirAll(filepath.Dir(archivedPath), 0o755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(b.objectPath(entry), archivedPath)
+}
+
+// prepared reports whether every file change of a pending entry was made,
+// judging from the files themselves. DeleteObjects is always rolled
+// forward, since its commit only drops the records of the keys whose data
+// was moved aside.
+func (b *FileBackend) prepared(entry *intent) bool {
+	switch entry.Op {
+	case opCreateBucket:
+		return exists(filepath.Join(b.dataDir, entry.Bucket))
+	case opDeleteBucket:
+		return !exists(filepath.Join(b.dataDir, entry.Bucket))
+	case opPutObject:
+		return !exists(b.path(entry.TmpPath))
+	case opPutDeleteMarker:
+		return !entry.Archive || !exists(b.objectPath(entry))
+	case opDeleteVersion:
+		return entry.Object == nil || !exists(b.versionPath(entry.Bucket, entry.Key, entry.Object.VersionID))
+	}
+	return true
+}
+
+// undo reverses the file changes of an entry that was not fully prepared.
+func (b *FileBackend) undo(entry *intent) {
+	switch entry.Op {
+	case opDeleteBucket:
+		if exists(b.path(entry.TmpPath)) {
+			os.Rename(b.path(entry.TmpPath), filepath.Join(b.dataDir, entry.Bucket))
+		}
+
+	case opPutObject:
+		if entry.Archive && !exists(b.objectPath(entry)) {
+			os.Rename(b.versionPath(entry.Bucket, entry.Key, entry.Replaced.VersionID), b.objectPath(entry))
+		}
+		os.Remove(b.path(entry.TmpPath))
+	}
+}
+
+// commit applies the metadata changes of entry.
+func (b *FileBackend) commit(tx *kv.Tx, entry *intent) error {
+	for _, key := range entry.Dropped {
+		err := tx.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch entry.Op {
+	case opCreateBucket:
+		return putBucket(tx, *entry.Record)
+
+	case opDeleteBucket:
+		return deleteBucketRecords(tx, entry.Bucket)
+
+	case opDeleteObjects:
+		// Only the objects whose data is actually gone lose their record,
+		// whether or not every removal succeeded.
+		for _, objectKey := range entry.Keys {
+			if exists(b.keyPath(entry.Bucket, objectKey)) {
+				continue
+			}
+			err := deleteObject(tx, entry.Bucket, objectKey)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case opPutObject, opPutDeleteMarker:
+		if entry.Archive {
+			archived := *entry.Replaced
+			archived.VersionID = reportedVersion(archived.VersionID)
+			err := appendHistory(tx, entry.Bucket, archived)
+			if err != nil {
+				return err
+			}
+		}
+		if entry.Op == opPutObject {
+			return putObject(tx, entry.Bucket, *entry.Object)
+		}
+
+		if entry.Replaced != nil {
+			err := deleteObject(tx, entry.Bucket, entry.Key)
+			if err != nil {
+				return err
+			}
+		}
+		return appendHistory(tx, entry.Bucket, *entry.Object)
+
+	case opDeleteVersion:
+		err := tx.Delete(entry.Removed)
+		if err != nil {
+			return err
+		}
+		if entry.Object == nil {
+			return nil
+		}
+		err = tx.Delete(entry.Promoted)
+		if err != nil {
+			return err
+		}
+		return putObject(tx, entry.Bucket, *entry.Object)
+	}
+
+	return fmt.Errorf("unknown operation %q", entry.Op)
+}
+
+// cleanup removes the files a committed entry left unreferenced.
+func (b *FileBackend) cleanup(entry *intent) {
+	bucketDir := filepath.Join(b.dataDir, entry.Bucket)
+
+	for _, garbage := range entry.Garbage {
+		path := b.path(garbage)
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("storage: failed to remove %s: %v", path, err)
+			continue
+		}
+		removeEmptyParents(bucketDir, filepath.Dir(path))
+	}
+
+	switch entry.Op {
+	case opDeleteBucket:
+		err := os.RemoveAll(b.path(entry.TmpPath))
+		if err != nil {
+			log.Printf("storage: failed to remove %s: %v", b.path(entry.TmpPath), err)
+		}
+	case opDeleteObjects:
+		for _, objectKey := range entry.Keys {
+			path := deletedPath(b.keyPath(entry.Bucket, objectKey))
+			err := os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("storage: failed to remove %s: %v", path, err)
+				continue
+			}
+			removeEmptyParents(bucketDir, filepath.Dir(path))
+		}
+	case opPutDeleteMarker:
+		removeEmptyParents(bucketDir, filepath.Dir(b.objectPath(entry)))
+	case opDeleteVersion:
+		if entry.Object != nil {
+			versionPath := b.versionPath(entry.Bucket, entry.Key, entry.Object.VersionID)
+			removeEmptyParents(bucketDir, filepath.Dir(versionPath))
+		}
+	}
+}
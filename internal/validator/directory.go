@@ -44,17 +44,22 @@ func validateExistingDir(dir string) error {
 		return nil
 	}
 
-	hasBucketsCSV := false
+	hasMetadata := false
+
+	onlyLockFile := true
 
 	for _, entry := range entries {
 		name := entry.Name()
-		if name == "buckets.csv" {
-			hasBucketsCSV = true
+		if name == "format" || name == "metadata.db" || name == "buckets.csv" {
+			hasMetadata = true
 			break
 		}
+		if name != ".lock" {
+			onlyLockFile = false
+		}
 	}
 
-	if !hasBucketsCSV {
+	if !hasMetadata && !onlyLockFile {
 		return errors.New("directory can not be used as data directory")
 	}
 
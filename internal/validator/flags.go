@@ -1,30 +1,152 @@
 package validator
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
+	"time"
+
+	"triple-s/internal/structure"
 )
 
-func InitFlags() (string, string, bool) {
-	port, dir, help := "", "", false
+func InitFlags() (structure.Server, bool) {
+	server, help := structure.Server{}, false
 
-	flag.StringVar(&port, "port", "8080", "Port number")
-	flag.StringVar(&dir, "dir", "./data", "Path to directory")
+	flag.StringVar(&server.Port, "port", "8080", "Port number")
+	flag.StringVar(&server.Dir, "dir", "./data", "Path to directory")
+	flag.StringVar(&server.Backend, "backend", "file", "Storage backend (file or memory)")
+	flag.StringVar(&server.Credentials, "credentials", "", "Path to the credentials file")
+	flag.DurationVar(&server.LifecycleInterval, "lifecycle-interval", time.Hour, "How often lifecycle rules are applied (0 disables them)")
+	flag.BoolVar(&server.LifecycleDryRun, "lifecycle-dry-run", false, "Only log the actions lifecycle rules would take")
 	flag.BoolVar(&help, "help", false, "Show help")
 	flag.Parse()
 
-	return port, dir, help
+	return server, help
+}
+
+func InitPresignFlags(args []string) (structure.Presign, error) {
+	presign := structure.Presign{}
+
+	flags := flag.NewFlagSet("presign", flag.ExitOnError)
+	flags.StringVar(&presign.Endpoint, "endpoint", "http://localhost:8080", "Server URL")
+	flags.StringVar(&presign.Region, "region", "us-east-1", "Signing region")
+	flags.StringVar(&presign.Method, "method", http.MethodGet, "HTTP method (GET or PUT)")
+	flags.StringVar(&presign.Bucket, "bucket", "", "Bucket name")
+	flags.StringVar(&presign.Key, "key", "", "Object key")
+	flags.DurationVar(&presign.Expires, "expires", 15*time.Minute, "Lifetime of the URL")
+	flags.StringVar(&presign.AccessKeyID, "access-key", "", "Access key ID")
+	flags.StringVar(&presign.SecretKey, "secret-key", "", "Secret access key")
+	flags.StringVar(&presign.Credentials, "credentials", "", "Credentials file to look the secret key up in")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return presign, err
+	}
+
+	if presign.Method != http.MethodGet && presign.Method != http.MethodPut {
+		return presign, fmt.Errorf("unsupported method %q, expected GET or PUT", presign.Method)
+	}
+	err = ValidateBucketName(presign.Bucket)
+	if err != nil {
+		return presign, err
+	}
+	if presign.Key == "" {
+		return presign, errors.New("object key is required")
+	}
+	if presign.Expires < time.Second || presign.Expires > 7*24*time.Hour {
+		return presign, errors.New("expires must be between 1s and 168h")
+	}
+	if presign.AccessKeyID == "" {
+		return presign, errors.New("access key is required")
+	}
+	if presign.SecretKey == "" && presign.Credentials == "" {
+		return presign, errors.New("either a secret key or a credentials file is required")
+	}
+
+	return presign, nil
+}
+
+// InitMigrateFlags parses the arguments of the migrate command and returns
+// the data directory to convert.
+func InitMigrateFlags(args []string) (string, error) {
+	var dir string
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.StringVar(&dir, "dir", "./data", "Path to directory")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return "", err
+	}
+	if flags.NArg() > 0 {
+		return "", fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	return dir, nil
+}
+
+// InitFsckFlags parses the arguments of the fsck command and returns the
+// data directory to check and whether to repair it.
+func InitFsckFlags(args []string) (string, bool, error) {
+	var dir string
+	var repair bool
+
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	flags.StringVar(&dir, "dir", "./data", "Path to directory")
+	flags.BoolVar(&repair, "repair", false, "Rebuild the metadata from the files on disk where they disagree")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return "", false, err
+	}
+	if flags.NArg() > 0 {
+		return "", false, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	return dir, repair, nil
 }
 
 func PrintUsage() {
 	fmt.Println(`Simple Storage Service.
 
 **Usage:**
-    triple-s [-port <N>] [-dir <S>]  
+    triple-s [-port <N>] [-dir <S>] [-backend <B>] [-credentials <F>]
+             [-lifecycle-interval <D>] [-lifecycle-dry-run]
+    triple-s presign -bucket <B> -key <K> -access-key <A> [-secret-key <S> | -credentials <F>]
+                     [-method GET|PUT] [-expires <D>] [-endpoint <URL>] [-region <R>]
+    triple-s migrate [-dir <S>]
+    triple-s fsck [-dir <S>] [-repair]
     triple-s --help
 
 **Options:**
-- --help     Show this screen.
-- --port N   Port number
-- --dir S    Path to the directory`)
+- --help           Show this screen.
+- --port N         Port number
+- --dir S          Path to the directory
+- --backend B      Storage backend: file (default) or memory
+- --credentials F  CSV file of access key / secret key pairs; enables
+                   AWS Signature Version 4 authentication
+- --lifecycle-interval D
+                   How often bucket lifecycle rules are applied, e.g. 1h
+                   (default); 0 disables them
+- --lifecycle-dry-run
+                   Log the objects lifecycle rules would delete without
+                   deleting them
+
+**Presign options:**
+- --method M       GET (default) or PUT
+- --expires D      Lifetime of the URL, e.g. 15m (default) or 24h; at most 168h
+- --endpoint URL   Server URL (default http://localhost:8080)
+- --region R       Signing region (default us-east-1)
+
+**Migrate:**
+    Converts a data directory written by an older version to the current
+    format, moving object data to its escaped path and reconciling the
+    metadata with the files on disk. Stop the server first.
+
+**Fsck:**
+    Checks every bucket, object and version in the metadata against the
+    files on disk, hashing each file against its ETag, and lists what
+    disagrees. Exits with status 1 if anything does. With -repair the
+    metadata is rebuilt from the files where they disagree.`)
 }
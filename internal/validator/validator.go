@@ -4,8 +4,14 @@ import (
 	"errors"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
+// MaxObjectKeyLength is the longest object key accepted, in bytes.
+const MaxObjectKeyLength = 1024
+
+var ErrKeyTooLong = errors.New("object key must be at most 1024 bytes long")
+
 func ValidateBucketName(name string) error {
 	if len(name) < 3 || len(name) > 63 {
 		return errors.New("bucket name must be between 3 and 63 characters long")
@@ -21,3 +27,37 @@ func ValidateBucketName(name string) error {
 
 	return nil
 }
+
+// ValidateObjectKey checks that key is non-empty UTF-8 of at most
+// MaxObjectKeyLength bytes. It may not contain a NUL byte, which the
+// metadata uses as a separator, or climb out of its bucket through ".."
+// segments.
+func ValidateObjectKey(key string) error {
+	if key == "" {
+		return errors.New("object key cannot be empty")
+	}
+	if len(key) > MaxObjectKeyLength {
+		return ErrKeyTooLong
+	}
+	if !utf8.ValidString(key) {
+		return errors.New("object key must be valid UTF-8")
+	}
+	if strings.IndexByte(key, 0) >= 0 {
+		return errors.New("object key cannot contain a NUL byte")
+	}
+
+	depth := 0
+	for _, segment := range strings.Split(key, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			depth--
+			if depth < 0 {
+				return errors.New("object key cannot refer outside its bucket")
+			}
+		default:
+			depth++
+		}
+	}
+	return nil
+}
@@ -5,40 +5,151 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"triple-s/internal/auth"
+	"triple-s/internal/lifecycle"
 	"triple-s/internal/router"
-	"triple-s/internal/structure"
+	"triple-s/internal/storage"
 	v "triple-s/internal/validator"
 )
 
 func main() {
-	port, dir, help := v.InitFlags()
+	if len(os.Args) > 1 && os.Args[1] == "presign" {
+		presign(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		fsck(os.Args[2:])
+		return
+	}
+
+	server, help := v.InitFlags()
 
 	if help {
 		v.PrintUsage()
 		return
 	}
 
-	err := v.ValidateDataDirectory(dir)
-	if err != nil {
-		log.Fatalf("Invalid data directory: %v", err)
+	if server.Backend == storage.BackendFile {
+		err := v.ValidateDataDirectory(server.Dir)
+		if err != nil {
+			log.Fatalf("Invalid data directory: %v", err)
+		}
+
+		err = os.MkdirAll(server.Dir, 0o755)
+		if err != nil {
+			log.Fatalf("Failed to create directory %s: %v", server.Dir, err)
+		}
 	}
 
-	err = os.MkdirAll(dir, 0o755)
+	backend, err := storage.NewBackend(server.Backend, server.Dir)
 	if err != nil {
-		log.Fatalf("Failed to create directory %s: %v", dir, err)
+		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 
-	server := structure.Server{
-		Dir:  dir,
-		Port: port,
+	var credentials *auth.Store
+	if server.Credentials != "" {
+		credentials, err = auth.LoadCredentials(server.Credentials)
+		if err != nil {
+			log.Fatalf("Failed to load credentials: %v", err)
+		}
 	}
 
-	mux := router.Router(&server)
+	if server.LifecycleInterval > 0 {
+		worker := lifecycle.NewWorker(backend, server.LifecycleInterval, server.LifecycleDryRun)
+		go worker.Run()
+	}
+
+	handler := router.Router(&server, backend, credentials)
 
-	fmt.Printf("Starting server on port %s, directory %s\n", port, dir)
-	err = http.ListenAndServe(":"+port, mux)
+	fmt.Printf("Starting server on port %s, directory %s, backend %s\n", server.Port, server.Dir, server.Backend)
+	err = http.ListenAndServe(":"+server.Port, handler)
 	if err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
+
+func presign(args []string) {
+	request, err := v.InitPresignFlags(args)
+	if err != nil {
+		log.Fatalf("Invalid presign arguments: %v", err)
+	}
+
+	credential := auth.Credential{
+		AccessKeyID:     request.AccessKeyID,
+		SecretAccessKey: request.SecretKey,
+	}
+	if credential.SecretAccessKey == "" {
+		credentials, err := auth.LoadCredentials(request.Credentials)
+		if err != nil {
+			log.Fatalf("Failed to load credentials: %v", err)
+		}
+
+		var ok bool
+		credential, ok = credentials.Lookup(request.AccessKeyID)
+		if !ok {
+			log.Fatalf("Access key %s not found in %s", request.AccessKeyID, request.Credentials)
+		}
+	}
+
+	url, err := auth.Presign(credential, request.Method, request.Endpoint, request.Region, request.Bucket, request.Key, request.Expires, time.Now())
+	if err != nil {
+		log.Fatalf("Failed to presign URL: %v", err)
+	}
+
+	fmt.Println(url)
+}
+
+func migrate(args []string) {
+	dir, err := v.InitMigrateFlags(args)
+	if err != nil {
+		log.Fatalf("Invalid migrate arguments: %v", err)
+	}
+
+	report, err := storage.Migrate(dir)
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	if report.UpToDate {
+		fmt.Printf("%s is already in format version %d\n", dir, storage.FormatVersion)
+		return
+	}
+	for _, line := range report.Reconciled {
+		fmt.Println(line)
+	}
+	fmt.Printf("Migrated %d buckets, %d objects and %d noncurrent versions in %s to format version %d\n",
+		report.Buckets, report.Objects, report.Versions, dir, storage.FormatVersion)
+}
+
+func fsck(args []string) {
+	dir, repair, err := v.InitFsckFlags(args)
+	if err != nil {
+		log.Fatalf("Invalid fsck arguments: %v", err)
+	}
+
+	report, err := storage.Fsck(dir, repair)
+	if err != nil {
+		log.Fatalf("Check failed: %v", err)
+	}
+
+	for _, line := range report.Problems {
+		fmt.Println(line)
+	}
+	fmt.Printf("Checked %d buckets, %d objects and %d noncurrent versions in %s: %d problems found\n",
+		report.Buckets, report.Objects, report.Versions, dir, len(report.Problems))
+	if len(report.Problems) == 0 {
+		return
+	}
+	if report.Repaired {
+		fmt.Println("Repairs applied")
+		return
+	}
+	fmt.Println("Nothing was changed; run with -repair to apply the resolutions above")
+	os.Exit(1)
+}